@@ -0,0 +1,199 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// repairStats counts what a round of anti-entropy repaired on a replica
+type repairStats struct {
+	ranges, pushed, pulled int
+}
+
+// antiEntropy repairs the divergence between the owned keys and their copies on every replica.
+// The Merkle trees of the owned range on both sides are compared top-down to find the sub-ranges
+// they disagree on, then only the keys of those sub-ranges are compared and copied either way
+func (r *replicator) antiEntropy(ctx context.Context) error {
+	pred := r.localNode.GetPredNode()
+	if pred == nil {
+		return nil
+	}
+	m := r.localNode.GetRank()
+	owned := chord.NewInterval(m, pred.GetID(), r.localNode.GetID(), chord.WithLeftOpen, chord.WithRightClosed)
+	items, err := r.itemsIn(owned)
+	if err != nil {
+		return err
+	}
+	tree := newMerkleTree(m, owned, merkleDepth, items)
+
+	for _, replica := range r.currentReplicas() {
+		stats, err := r.repair(ctx, replica, owned, tree)
+		if err != nil {
+			return errors.Wrapf(err, "unable to repair %s", replica)
+		}
+		if stats.ranges > 0 {
+			logrus.Infof("anti-entropy with %s: %d ranges differed, pushed %d keys, pulled %d keys", replica, stats.ranges, stats.pushed, stats.pulled)
+		}
+	}
+	return nil
+}
+
+// repair makes the replica and the local node agree on the keys of the owned range
+func (r *replicator) repair(ctx context.Context, replica chord.NodeRef, owned chord.Interval, tree *merkleTree) (repairStats, error) {
+	var stats repairStats
+	keyRange := &pb.KeyRange{Start: owned.Start.AsU64(), End: owned.End.AsU64()}
+
+	indices := []int32{0}
+	for level := 0; level < len(tree.levels) && len(indices) > 0; level++ {
+		if level > 0 {
+			indices = tree.children(level-1, indices)
+		}
+		var resp *pb.MerkleNodesResponse
+		err := r.dialer.Call(ctx, replica.GetBind(), "GetMerkleNodes", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.GetMerkleNodes(ctx, &pb.MerkleNodesRequest{
+				KeyRange: keyRange,
+				Depth:    merkleDepth,
+				Level:    int32(level),
+				Indices:  indices,
+			})
+			return err
+		})
+		if err != nil {
+			return stats, err
+		}
+		indices = tree.diff(level, indices, resp.Hashes)
+	}
+	if len(indices) == 0 {
+		return stats, nil
+	}
+
+	// indices are now the leaves that differ
+	stats.ranges = len(indices)
+	var (
+		leaves    []chord.Interval
+		keyRanges []*pb.KeyRange
+	)
+	for _, i := range indices {
+		leaf := tree.leaves[i]
+		leaves = append(leaves, leaf)
+		keyRanges = append(keyRanges, &pb.KeyRange{Start: leaf.Start.AsU64(), End: leaf.End.AsU64()})
+	}
+	var resp *pb.DigestsResponse
+	err := r.dialer.Call(ctx, replica.GetBind(), "GetDigests", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.GetDigests(ctx, &pb.DigestsRequest{KeyRanges: keyRanges})
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	local := make(map[string]storage.Item)
+	for _, leaf := range leaves {
+		items, err := r.itemsIn(leaf)
+		if err != nil {
+			return stats, err
+		}
+		for _, item := range items {
+			local[item.Key] = item
+		}
+	}
+	remote := make(map[string]storage.Item, len(resp.Digests))
+	for _, kv := range resp.Digests {
+		remote[kv.Key] = itemFromProto(kv)
+	}
+
+	var push []storage.Item
+	for key, item := range local {
+		if digest, ok := remote[key]; !ok || digestOf(item).NewerThan(digest) {
+			push = append(push, item)
+		}
+	}
+	// the newer copies of the replica are read a leaf at a time
+	pull := make([][]string, len(tree.leaves))
+	m := r.localNode.GetRank()
+	for key, digest := range remote {
+		if item, ok := local[key]; ok && !digest.NewerThan(digestOf(item)) {
+			continue
+		}
+		if i := leafOf(m, owned, tree.leaves, keyID(key, m)); i >= 0 {
+			pull[i] = append(pull[i], key)
+		}
+	}
+	for _, keys := range pull {
+		if len(keys) == 0 {
+			continue
+		}
+		reads, err := r.readReplicas(ctx, replica, keys)
+		if err != nil {
+			return stats, err
+		}
+		for _, read := range reads {
+			if read.err != nil {
+				return stats, read.err
+			}
+			if !read.found {
+				// reaped since
+				continue
+			}
+			if err := r.merge(read.item); err != nil {
+				return stats, err
+			}
+			stats.pulled++
+		}
+	}
+	if err := r.pushItems(ctx, replica, push); err != nil {
+		return stats, err
+	}
+	stats.pushed = len(push)
+	return stats, nil
+}
+
+// digestOf is the item without its value, as compared by anti-entropy
+func digestOf(item storage.Item) storage.Item {
+	return storage.Item{Key: item.Key, Version: item.Version, ExpiresAt: item.ExpiresAt, Deleted: item.Deleted}
+}
+
+// GetMerkleNodes reads the hashes of nodes of the Merkle tree of the keys stored on the local node in a range
+func (s *Server) GetMerkleNodes(_ context.Context, req *pb.MerkleNodesRequest) (*pb.MerkleNodesResponse, error) {
+	if req.KeyRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "the key range must be set")
+	}
+	if req.Depth < 0 || req.Depth > maxMerkleDepth {
+		return nil, status.Errorf(codes.InvalidArgument, "the depth must be between 0 and %d, got %d", maxMerkleDepth, req.Depth)
+	}
+
+	m := s.localNode.GetRank()
+	iv := chord.NewInterval(m, chord.ID(req.KeyRange.Start), chord.ID(req.KeyRange.End), chord.WithLeftOpen, chord.WithRightClosed)
+	items, err := s.replicator.itemsIn(iv)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := newMerkleTree(m, iv, int(req.Depth), items).nodes(int(req.Level), req.Indices)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.MerkleNodesResponse{Hashes: hashes}, nil
+}
+
+// GetDigests reads the keys stored on the local node in the ranges with their versions, without their values
+func (s *Server) GetDigests(_ context.Context, req *pb.DigestsRequest) (*pb.DigestsResponse, error) {
+	m := s.localNode.GetRank()
+	var digests []*pb.KeyValue
+	for _, keyRange := range req.KeyRanges {
+		iv := chord.NewInterval(m, chord.ID(keyRange.Start), chord.ID(keyRange.End), chord.WithLeftOpen, chord.WithRightClosed)
+		items, err := s.replicator.itemsIn(iv)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			digests = append(digests, itemToProto(digestOf(item)))
+		}
+	}
+	return &pb.DigestsResponse{Digests: digests}, nil
+}
@@ -0,0 +1,174 @@
+package chordio
+
+import (
+	"context"
+	"fmt"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAntiEntropy(t *testing.T) {
+	pair := newReplicatedPair()
+	defer pair.stop()
+	n0, n4 := pair.n0, pair.n4
+
+	stored := func(n testNode, key string) storage.Item {
+		item, _, err := n.s.store.Get(key)
+		assert.Nil(t, err)
+		return item
+	}
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keys = append(keys, key)
+		assert.Nil(t, n0.put(key, "a", pb.Consistency_ALL))
+	}
+
+	// the replicas diverge behind the back of the replication
+	diverge := func(i int) (owner, replica testNode) {
+		owner, replica = pair.ownerOf(keys[i])
+		item := stored(owner, keys[i])
+		switch i % 4 {
+		case 0:
+			// a write missed by the replica
+			item.Value, item.Version = []byte("b"), item.Version+1
+			assert.Nil(t, owner.s.store.Put(item))
+		case 1:
+			// a write missed by the owner, e.g. before it took over the key
+			item.Value, item.Version = []byte("c"), item.Version+1
+			assert.Nil(t, replica.s.store.Put(item))
+		case 2:
+			// a delete missed by the replica
+			item.Value, item.Version, item.Deleted = nil, item.Version+1, true
+			assert.Nil(t, owner.s.store.Put(item))
+		case 3:
+			// a key lost by the replica
+			assert.Nil(t, replica.s.store.Delete(keys[i]))
+		}
+		return owner, replica
+	}
+	diverged := []int{0, 1, 2, 3, 6, 11}
+	for _, i := range diverged {
+		diverge(i)
+	}
+
+	ctx := context.Background()
+	assert.Nil(t, n0.s.replicator.antiEntropy(ctx))
+	assert.Nil(t, n4.s.replicator.antiEntropy(ctx))
+
+	t.Run("the replicas agree on every key", func(t *testing.T) {
+		for _, key := range keys {
+			owner, replica := pair.ownerOf(key)
+			assert.Equal(t, stored(owner, key), stored(replica, key), key)
+		}
+	})
+
+	t.Run("the newest copy wins", func(t *testing.T) {
+		assert.Equal(t, "b", string(stored(n0, keys[0]).Value))
+		assert.Equal(t, "c", string(stored(n0, keys[1]).Value))
+		assert.True(t, stored(n0, keys[2]).Deleted)
+		assert.Equal(t, "a", string(stored(n0, keys[3]).Value))
+	})
+
+	t.Run("the replicas that agree have the same tree", func(t *testing.T) {
+		for _, pair := range [][2]testNode{{n0, n4}, {n4, n0}} {
+			n, replica := pair[0], pair[1]
+			pred := n.s.localNode.GetPredNode()
+			owned := chord.NewInterval(3, pred.GetID(), chord.ID(n.id), chord.WithLeftOpen, chord.WithRightClosed)
+			c, close := replica.getClient()
+			resp, err := c.GetMerkleNodes(ctx, &pb.MerkleNodesRequest{
+				KeyRange: &pb.KeyRange{Start: owned.Start.AsU64(), End: owned.End.AsU64()},
+				Depth:    merkleDepth,
+				Indices:  []int32{0},
+			})
+			close()
+			assert.Nil(t, err)
+
+			items, _ := n.s.replicator.itemsIn(owned)
+			assert.Equal(t, newMerkleTree(3, owned, merkleDepth, items).levels[0], resp.Hashes)
+		}
+	})
+}
+
+func TestAntiEntropyLoop(t *testing.T) {
+	newNode := func(id int) testNode {
+		return newNodeWithConfig(Config{
+			ID:   chord.ID(id),
+			M:    3,
+			Bind: inprocAddr(id),
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+			Replication: ReplicationConfig{
+				Factor: 2,
+			},
+			AntiEntropy: AntiEntropyConfig{
+				Period: 20 * time.Millisecond,
+			},
+		})
+	}
+	n0, n4 := newNode(0), newNode(4)
+	defer n0.stop()
+	defer n4.stop()
+	n4.join(n0)
+	stabilizeRounds(3, n0, n4)
+
+	resp, err := n0.get("key", pb.Consistency_ONE)
+	assert.Nil(t, err)
+	replica := n4
+	if resp.Owner.Id == n4.id {
+		replica = n0
+	}
+	assert.Nil(t, n0.put("key", "a", pb.Consistency_ALL))
+
+	// the replica loses the key behind the back of the replication, and gets it back from the owner
+	assert.Nil(t, replica.s.store.Delete("key"))
+	assert.Eventually(t, func() bool {
+		return replica.stores("key")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGetReplicas(t *testing.T) {
+	pair := newReplicatedPair()
+	defer pair.stop()
+	n0, n4 := pair.n0, pair.n4
+
+	large := make([]byte, replicationBatchSize*2/3)
+	for _, key := range []string{"large-1", "large-2"} {
+		assert.Nil(t, n0.s.store.Put(storage.Item{Key: key, Value: large, Version: 1}))
+	}
+	keys := []string{"large-1", "absent", "large-2"}
+
+	t.Run("the values past a single message are left out", func(t *testing.T) {
+		c, close := n0.getClient()
+		defer close()
+		resp, err := c.GetReplicas(context.Background(), &pb.GetReplicasRequest{Keys: keys})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(resp.Replicas))
+		assert.True(t, resp.Replicas[0].Found)
+		assert.False(t, resp.Replicas[0].Chunked)
+		assert.Equal(t, large, resp.Replicas[0].Item.Value)
+		assert.False(t, resp.Replicas[1].Found)
+		assert.True(t, resp.Replicas[2].Found)
+		assert.True(t, resp.Replicas[2].Chunked)
+		assert.Empty(t, resp.Replicas[2].Item.Value)
+	})
+
+	t.Run("the values left out are read in chunks", func(t *testing.T) {
+		reads, err := n4.s.replicator.readReplicas(context.Background(), &PBNodeRef{Id: n0.id, Bind: n0.addr}, keys)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(reads))
+		for i, found := range []bool{true, false, true} {
+			assert.Nil(t, reads[i].err)
+			assert.Equal(t, found, reads[i].found, keys[i])
+			if found {
+				assert.Equal(t, large, reads[i].item.Value, keys[i])
+			}
+		}
+	})
+}
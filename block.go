@@ -0,0 +1,137 @@
+package chordio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// MaxBlockSize bounds the size of the blocks, larger files are split into blocks listed by a manifest
+const MaxBlockSize = 1 << 20
+
+var errEmptyBlock = status.Error(codes.InvalidArgument, "the block must not be empty")
+
+// BlockID is the ID of a block, the SHA-256 of its data
+func BlockID(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// blockKeyPrefix starts the keys the blocks are stored under
+const blockKeyPrefix = "block/"
+
+// blockKey is the key a block is stored under, so that the blocks are replicated, handed over
+// and repaired like any key. keyID places it at the ID assigned to the ID of the block
+func blockKey(id []byte) string {
+	return blockKeyPrefix + hex.EncodeToString(id)
+}
+
+// blockIDOf returns the ID of the block stored under the key, if it's the key of a block
+func blockIDOf(key string) ([]byte, bool) {
+	if !strings.HasPrefix(key, blockKeyPrefix) {
+		return nil, false
+	}
+	id, err := hex.DecodeString(key[len(blockKeyPrefix):])
+	if err != nil || len(id) != sha256.Size {
+		return nil, false
+	}
+	return id, true
+}
+
+// PutBlock stores an immutable block on the owner of AssignID(BlockID(data)), which copies it to its
+// replicas like a value. The block can only be read by its ID, storing the same data again is harmless
+func (s *Server) PutBlock(ctx context.Context, req *pb.PutBlockRequest) (*pb.PutBlockResponse, error) {
+	logger := logrus.WithField("method", "Server.PutBlock")
+	logger.Debugf("size=%d", len(req.Data))
+
+	if len(req.Data) == 0 {
+		return nil, errEmptyBlock
+	}
+	if len(req.Data) > MaxBlockSize {
+		return nil, status.Errorf(codes.InvalidArgument, "the block is larger than %d bytes", MaxBlockSize)
+	}
+
+	id := BlockID(req.Data)
+	var resp *pb.PutBlockResponse
+	if forwarded, err := s.forwardToOwner(ctx, blockKey(id), req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Call(ctx, owner.GetBind(), "PutBlock", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.PutBlock(ctx, &pb.PutBlockRequest{Data: req.Data, Forwarded: true, Consistency: req.Consistency})
+			return err
+		})
+	}); forwarded {
+		return resp, err
+	}
+
+	if _, err := s.replicator.write(ctx, storage.Item{Key: blockKey(id), Value: req.Data}, req.Consistency, nil); err != nil {
+		return nil, err
+	}
+	return &pb.PutBlockResponse{
+		Id:       id,
+		Position: keyID(blockKey(id), s.localNode.GetRank()).AsU64(),
+		Owner:    asProtobufRef(s.localNode),
+	}, nil
+}
+
+// GetBlock reads a block from the owner of its ID and as many replicas as the consistency level requires.
+// The data read is verified against the ID, if it doesn't match, the block is read from the replicas
+// until an intact copy is found, which replaces the corrupted ones. Fails with DataLoss if there's none
+func (s *Server) GetBlock(ctx context.Context, req *pb.GetBlockRequest) (*pb.GetBlockResponse, error) {
+	logger := logrus.WithField("method", "Server.GetBlock")
+	logger.Debugf("id=%x", req.Id)
+
+	if len(req.Id) != sha256.Size {
+		return nil, status.Errorf(codes.InvalidArgument, "the block ID must be a SHA-256, got %d bytes", len(req.Id))
+	}
+
+	var resp *pb.GetBlockResponse
+	if forwarded, err := s.forwardToOwner(ctx, blockKey(req.Id), req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Call(ctx, owner.GetBind(), "GetBlock", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.GetBlock(ctx, &pb.GetBlockRequest{Id: req.Id, Forwarded: true, Consistency: req.Consistency})
+			return err
+		})
+	}); forwarded {
+		return resp, err
+	}
+
+	now := time.Now()
+	intact := func(read replicaRead) bool {
+		return read.err == nil && read.found && read.item.Live(now) && bytes.Equal(BlockID(read.item.Value), req.Id)
+	}
+	read, _, err := s.replicator.read(ctx, blockKey(req.Id), req.Consistency)
+	if err != nil {
+		return nil, err
+	}
+	if intact(read) {
+		return &pb.GetBlockResponse{Data: read.item.Value, Owner: asProtobufRef(s.localNode)}, nil
+	}
+
+	missing := !read.found || !read.item.Live(now)
+	for _, replica := range s.replicator.currentReplicas() {
+		read := s.replicator.readReplica(ctx, replica, blockKey(req.Id))
+		if !intact(read) {
+			missing = missing && (read.err != nil || !read.found || !read.item.Live(now))
+			continue
+		}
+		if !missing {
+			logger.Warnf("block %x is corrupted, repairing it from %s", req.Id, replica)
+		}
+		// a new version replaces the corrupted copies everywhere
+		if _, err := s.replicator.write(ctx, storage.Item{Key: blockKey(req.Id), Value: read.item.Value}, pb.Consistency_ONE, nil); err != nil {
+			logger.Warnf("unable to repair block %x: %s", req.Id, err)
+		}
+		return &pb.GetBlockResponse{Data: read.item.Value, Owner: asProtobufRef(s.localNode)}, nil
+	}
+	if missing {
+		return nil, status.Errorf(codes.NotFound, "block %x not found", req.Id)
+	}
+	return nil, status.Errorf(codes.DataLoss, "every copy of block %x is corrupted", req.Id)
+}
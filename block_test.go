@@ -0,0 +1,85 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	ctx := context.Background()
+	pair := newReplicatedPair()
+	defer pair.stop()
+
+	c, close := pair.n0.getClient()
+	defer close()
+
+	data := []byte("the quick brown fox")
+	id := BlockID(data)
+	key := blockKey(id)
+	corrupt := func(n testNode) {
+		item, _, _ := n.s.store.Get(key)
+		item.Value = []byte("the quick brown cat")
+		assert.Nil(t, n.s.store.Put(item))
+	}
+
+	resp, err := c.PutBlock(ctx, &pb.PutBlockRequest{Data: data, Consistency: pb.Consistency_ALL})
+	assert.Nil(t, err)
+	assert.Equal(t, id, resp.Id)
+	assert.Equal(t, node.AssignID(id, 3).AsU64(), resp.Position)
+	owner, replica := pair.ownerOf(key)
+	assert.Equal(t, owner.id, resp.Owner.Id)
+
+	t.Run("a block is read by its ID", func(t *testing.T) {
+		// the block is replicated like any key
+		assert.True(t, replica.stores(key))
+		get, err := c.GetBlock(ctx, &pb.GetBlockRequest{Id: id})
+		assert.Nil(t, err)
+		assert.Equal(t, data, get.Data)
+		assert.Equal(t, owner.id, get.Owner.Id)
+	})
+
+	t.Run("a corrupted block is read from an intact replica, which repairs it", func(t *testing.T) {
+		corrupt(owner)
+		get, err := c.GetBlock(ctx, &pb.GetBlockRequest{Id: id})
+		assert.Nil(t, err)
+		assert.Equal(t, data, get.Data)
+		item, _, _ := owner.s.store.Get(key)
+		assert.Equal(t, data, item.Value)
+	})
+
+	t.Run("a block without an intact copy is lost", func(t *testing.T) {
+		corrupt(owner)
+		corrupt(replica)
+		_, err := c.GetBlock(ctx, &pb.GetBlockRequest{Id: id})
+		assert.Equal(t, codes.DataLoss, status.Code(err))
+	})
+
+	t.Run("an unknown block isn't found", func(t *testing.T) {
+		_, err := c.GetBlock(ctx, &pb.GetBlockRequest{Id: BlockID([]byte("unknown"))})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("blocks can't be written as keys", func(t *testing.T) {
+		_, err := c.Put(ctx, &pb.PutRequest{Key: key, Value: []byte("overwritten")})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = c.Delete(ctx, &pb.DeleteRequest{Key: key})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = sendChunks(c, key, "upload", 0, []byte("overwritten"), BlockID([]byte("overwritten")))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("invalid blocks", func(t *testing.T) {
+		_, err := c.PutBlock(ctx, &pb.PutBlockRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = c.PutBlock(ctx, &pb.PutBlockRequest{Data: make([]byte, MaxBlockSize+1)})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = c.GetBlock(ctx, &pb.GetBlockRequest{Id: []byte("short")})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+}
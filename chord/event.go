@@ -0,0 +1,120 @@
+package chord
+
+import (
+	"fmt"
+	"github.com/kevinjqiu/chordio/pb"
+	"time"
+)
+
+// EventType values are the same as pb.EventType's
+type EventType int
+
+const (
+	EventPredecessorChanged EventType = iota + 1
+	EventSuccessorChanged
+	EventFingerUpdated
+	EventJoined
+	EventLeft
+	EventStabilized
+	EventRangeAcquired
+	EventRangeLost
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPredecessorChanged:
+		return "PREDECESSOR_CHANGED"
+	case EventSuccessorChanged:
+		return "SUCCESSOR_CHANGED"
+	case EventFingerUpdated:
+		return "FINGER_UPDATED"
+	case EventJoined:
+		return "JOINED"
+	case EventLeft:
+		return "LEFT"
+	case EventStabilized:
+		return "STABILIZED"
+	case EventRangeAcquired:
+		return "RANGE_ACQUIRED"
+	case EventRangeLost:
+		return "RANGE_LOST"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event is a change in the local node's view of the ring
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Old and New are the nodes before and after a predecessor, successor or finger change.
+	// For EventJoined New is the successor found through the introducer.
+	Old, New NodeRef
+	// FingerIndex is the finger table entry changed by EventFingerUpdated
+	FingerIndex int
+	// NumChanges is the number of changes of the neighbours and finger table entries reported by EventStabilized
+	NumChanges int
+	// Range is the key range acquired or lost by EventRangeAcquired and EventRangeLost
+	Range Interval
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case EventFingerUpdated:
+		return fmt.Sprintf("%s i=%d %v -> %v", e.Type, e.FingerIndex, e.Old, e.New)
+	case EventStabilized:
+		return fmt.Sprintf("%s changes=%d", e.Type, e.NumChanges)
+	case EventRangeAcquired, EventRangeLost:
+		return fmt.Sprintf("%s %s", e.Type, e.Range)
+	default:
+		return fmt.Sprintf("%s %v -> %v", e.Type, e.Old, e.New)
+	}
+}
+
+func (e Event) AsProtobufEvent() *pb.Event {
+	pbe := &pb.Event{
+		Type:        pb.EventType(e.Type),
+		Timestamp:   e.Time.UnixNano(),
+		FingerIndex: int32(e.FingerIndex),
+		NumChanges:  int32(e.NumChanges),
+	}
+	if e.Old != nil {
+		pbe.Old = &pb.Node{Id: e.Old.GetID().AsU64(), Bind: e.Old.GetBind()}
+	}
+	if e.New != nil {
+		pbe.New = &pb.Node{Id: e.New.GetID().AsU64(), Bind: e.New.GetBind()}
+	}
+	if e.Type == EventRangeAcquired || e.Type == EventRangeLost {
+		pbe.Range = &pb.KeyRange{Start: e.Range.Start.AsU64(), End: e.Range.End.AsU64()}
+	}
+	return pbe
+}
+
+// OwnershipChange returns the key ranges acquired and lost by the node self
+// when its predecessor changes from oldPred to newPred.
+// A node owns (pred, self], nothing if the predecessor is unknown (nil)
+// and the whole ring if it is its own predecessor.
+func OwnershipChange(m Rank, self ID, oldPred, newPred NodeRef) (acquired, lost *Interval) {
+	switch {
+	case oldPred == nil && newPred == nil:
+		return nil, nil
+	case oldPred == nil:
+		iv := NewInterval(m, newPred.GetID(), self, WithLeftOpen, WithRightClosed)
+		return &iv, nil
+	case newPred == nil:
+		iv := NewInterval(m, oldPred.GetID(), self, WithLeftOpen, WithRightClosed)
+		return nil, &iv
+	case oldPred.GetID() == newPred.GetID():
+		return nil, nil
+	}
+
+	closer := NewInterval(m, oldPred.GetID(), self, WithLeftOpen, WithRightOpen)
+	if closer.Has(newPred.GetID()) {
+		// the new predecessor sits between the old one and us, we hand (oldPred, newPred] over to it
+		iv := NewInterval(m, oldPred.GetID(), newPred.GetID(), WithLeftOpen, WithRightClosed)
+		return nil, &iv
+	}
+	// the old predecessor is gone, we take over (newPred, oldPred]
+	iv := NewInterval(m, newPred.GetID(), oldPred.GetID(), WithLeftOpen, WithRightClosed)
+	return &iv, nil
+}
@@ -0,0 +1,61 @@
+package chord
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type testNodeRef ID
+
+func (r testNodeRef) GetID() ID       { return ID(r) }
+func (r testNodeRef) GetBind() string { return "" }
+func (r testNodeRef) String() string  { return fmt.Sprintf("<T %d>", r) }
+
+func TestOwnershipChange(t *testing.T) {
+	m := Rank(3)
+	self := ID(5)
+
+	t.Run("unchanged predecessor", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, testNodeRef(2), testNodeRef(2))
+		assert.Nil(t, acquired)
+		assert.Nil(t, lost)
+	})
+
+	t.Run("predecessor becomes known", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, nil, testNodeRef(2))
+		assert.Equal(t, "(2, 5]", acquired.String())
+		assert.Nil(t, lost)
+	})
+
+	t.Run("predecessor becomes unknown", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, testNodeRef(2), nil)
+		assert.Nil(t, acquired)
+		assert.Equal(t, "(2, 5]", lost.String())
+	})
+
+	t.Run("a node joins between the predecessor and us", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, testNodeRef(2), testNodeRef(3))
+		assert.Nil(t, acquired)
+		assert.Equal(t, "(2, 3]", lost.String())
+	})
+
+	t.Run("the predecessor leaves", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, testNodeRef(3), testNodeRef(7))
+		assert.Equal(t, "(7, 3]", acquired.String())
+		assert.True(t, acquired.Has(0))
+		assert.Nil(t, lost)
+	})
+
+	t.Run("a lone node learns about its first peer", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, testNodeRef(5), testNodeRef(1))
+		assert.Nil(t, acquired)
+		assert.Equal(t, "(5, 1]", lost.String())
+	})
+
+	t.Run("the last peer leaves", func(t *testing.T) {
+		acquired, lost := OwnershipChange(m, self, testNodeRef(1), testNodeRef(5))
+		assert.Equal(t, "(5, 1]", acquired.String())
+		assert.Nil(t, lost)
+	})
+}
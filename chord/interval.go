@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"math/bits"
 )
 
 type IntervalOption func(i *Interval)
@@ -93,6 +94,32 @@ func NewInterval(m Rank, start, end ID, options ...IntervalOption) Interval {
 	return i
 }
 
+// Split divides the range (Start, End] into n contiguous sub-ranges (Start, x1], (x1, x2]...(xn-1, End]
+// of about the same width, in ring order. Start == End is the whole ring.
+// There are fewer sub-ranges if the range is narrower than n, none of them is empty
+func (i Interval) Split(n int) []Interval {
+	max := pow2(uint32(i.m))
+	width := (uint64(i.End) + max - uint64(i.Start)) % max
+	if width == 0 {
+		width = max
+	}
+	if uint64(n) > width {
+		n = int(width)
+	}
+
+	subs := make([]Interval, 0, n)
+	start := i.Start
+	for k := 1; k <= n; k++ {
+		// width*k/n without overflowing, width is at most 2**63
+		hi, lo := bits.Mul64(width, uint64(k))
+		offset, _ := bits.Div64(hi, lo, uint64(n))
+		end := i.Start.Add(ID(offset), i.m)
+		subs = append(subs, NewInterval(i.m, start, end, WithLeftOpen, WithRightClosed))
+		start = end
+	}
+	return subs
+}
+
 func pow2(exp uint32) uint64 {
 	return uint64(math.Pow(2, float64(exp)))
 }
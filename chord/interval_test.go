@@ -145,3 +145,58 @@ func TestInterval_Has(t *testing.T) {
 		}
 	})
 }
+
+func TestInterval_Split(t *testing.T) {
+	strings := func(ivs []Interval) []string {
+		var s []string
+		for _, iv := range ivs {
+			s = append(s, iv.String())
+		}
+		return s
+	}
+
+	t.Run("the range is divided evenly", func(t *testing.T) {
+		iv := NewInterval(7, 10, 50, WithLeftOpen, WithRightClosed)
+		assert.Equal(t, []string{"(10, 20]", "(20, 30]", "(30, 40]", "(40, 50]"}, strings(iv.Split(4)))
+	})
+
+	t.Run("the range crosses 0", func(t *testing.T) {
+		iv := NewInterval(7, 120, 8, WithLeftOpen, WithRightClosed)
+		assert.Equal(t, []string{"(120, 0]", "(0, 8]"}, strings(iv.Split(2)))
+	})
+
+	t.Run("the whole ring", func(t *testing.T) {
+		iv := NewInterval(3, 5, 5, WithLeftOpen, WithRightClosed)
+		assert.Equal(t, []string{"(5, 1]", "(1, 5]"}, strings(iv.Split(2)))
+	})
+
+	t.Run("every ID is in exactly one sub-range", func(t *testing.T) {
+		iv := NewInterval(7, 100, 37, WithLeftOpen, WithRightClosed)
+		subs := iv.Split(7)
+		for id := ID(0); id < 128; id++ {
+			var n int
+			for _, sub := range subs {
+				if sub.Has(id) {
+					n++
+				}
+			}
+			if iv.Has(id) {
+				assert.Equal(t, 1, n, id)
+			} else {
+				assert.Equal(t, 0, n, id)
+			}
+		}
+	})
+
+	t.Run("a narrow range has fewer sub-ranges", func(t *testing.T) {
+		iv := NewInterval(7, 10, 12, WithLeftOpen, WithRightClosed)
+		assert.Equal(t, []string{"(10, 11]", "(11, 12]"}, strings(iv.Split(4)))
+	})
+
+	t.Run("the widest ring", func(t *testing.T) {
+		iv := NewInterval(63, 0, 0, WithLeftOpen, WithRightClosed)
+		subs := iv.Split(2)
+		assert.Equal(t, ID(1<<62), subs[0].End)
+		assert.Equal(t, ID(0), subs[1].End)
+	})
+}
@@ -0,0 +1,87 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/telemetry"
+	"github.com/kevinjqiu/chordio/transport"
+	"go.opentelemetry.io/otel/plugin/grpctrace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Dialer connects a local node to the remote nodes. Every node has its own,
+// so that the nodes of a process don't share their credentials nor their retry budget
+type Dialer struct {
+	// creds of the connections to the remote nodes, plaintext if nil
+	creds  credentials.TransportCredentials
+	policy RPCPolicy
+	budget *retryBudget
+}
+
+// NewDialer returns a dialer connecting to the remote nodes with creds, plaintext if nil,
+// whose calls follow policy
+func NewDialer(creds credentials.TransportCredentials, policy RPCPolicy) *Dialer {
+	policy = policy.withDefaults()
+	return &Dialer{
+		creds:  creds,
+		policy: policy,
+		budget: newRetryBudget(policy.RetryBudget),
+	}
+}
+
+// WithDialer makes the node connect to the remote nodes with d instead of plaintext and the default RPC policy
+func WithDialer(d *Dialer) LocalOption {
+	return func(n *localNode) {
+		n.dialer = d
+	}
+}
+
+func (d *Dialer) dialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(transport.Dial),
+		grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(telemetry.Tracer())),
+		grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(telemetry.Tracer())),
+	}
+	if d.creds != nil {
+		return append(opts, grpc.WithTransportCredentials(d.creds))
+	}
+	return append(opts, grpc.WithInsecure())
+}
+
+// NewRemote returns the node at bind, the calls to which are made through d
+func (d *Dialer) NewRemote(ctx context.Context, bind string) (chord.RemoteNode, error) {
+	rn := &remoteNode{
+		Tracer: telemetry.Tracer(),
+		bind:   bind,
+		dialer: d,
+	}
+	if err := rn.init(ctx); err != nil {
+		return nil, err
+	}
+	return rn, nil
+}
+
+// NewRemote returns the node at bind, dialed in plaintext with the default RPC policy.
+//
+// Deprecated: use the NewRemote of a Dialer, which holds the credentials and the retry budget of the calls
+func NewRemote(ctx context.Context, bind string) (chord.RemoteNode, error) {
+	return NewDialer(nil, RPCPolicy{}).NewRemote(ctx, bind)
+}
+
+// Call invokes the RPC named method on the node at bind, following the RPC policy like the calls of the remote nodes
+func (d *Dialer) Call(ctx context.Context, bind string, method string, f func(ctx context.Context, client pb.ChordClient) error) error {
+	return (&remoteNode{bind: bind, dialer: d}).call(ctx, method, f)
+}
+
+// Stream opens a streaming RPC on the node at bind. Unlike Call, there's no deadline nor retries,
+// a stream lasts as long as the data it carries: it's bounded by ctx
+func (d *Dialer) Stream(ctx context.Context, bind string, f func(ctx context.Context, client pb.ChordClient) error) error {
+	client, close, err := (&remoteNode{bind: bind, dialer: d}).getClient()
+	if err != nil {
+		return err
+	}
+	defer close()
+	return f(ctx, client)
+}
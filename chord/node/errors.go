@@ -14,3 +14,7 @@ var (
 func errNodeNotFound(id chord.ID) error {
 	return errors.New(fmt.Sprintf("node %d s not known to the current local node", id))
 }
+
+func errLookupStuck(n chord.NodeRef) error {
+	return errors.New(fmt.Sprintf("the lookup cannot make progress past node %s", n))
+}
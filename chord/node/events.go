@@ -0,0 +1,87 @@
+package node
+
+import (
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// subscriberBufferSize is how many events a subscriber can fall behind
+// before further events are dropped for it
+const subscriberBufferSize = 256
+
+// eventBroker fans the events of a local node out to its subscribers.
+// Publishing never blocks: a subscriber that doesn't keep up misses events.
+type eventBroker struct {
+	mu          *sync.Mutex
+	nextID      int
+	subscribers map[int]chan chord.Event
+	closed      bool
+}
+
+func (b *eventBroker) publish(e chord.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logrus.Warnf("subscriber %d is falling behind, dropping event: %s", id, e)
+		}
+	}
+}
+
+func (b *eventBroker) subscribe() (<-chan chord.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan chord.Event, subscriberBufferSize)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if _, ok := b.subscribers[id]; !ok {
+				// already closed by the broker
+				return
+			}
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+}
+
+// close ends all subscriptions, events published afterwards are discarded
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for id, ch := range b.subscribers {
+		delete(b.subscribers, id)
+		close(ch)
+	}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		mu:          new(sync.Mutex),
+		subscribers: make(map[int]chan chord.Event),
+	}
+}
@@ -52,6 +52,24 @@ type fingerTable struct {
 	neighbourhood map[chord.ID]chord.NodeRef
 }
 
+// clone makes a copy of the finger table which can be changed without affecting the original
+func (ft *fingerTable) clone() *fingerTable {
+	c := &fingerTable{
+		ownerID:       ft.ownerID,
+		m:             ft.m,
+		entries:       make([]chord.FingerTableEntry, len(ft.entries)),
+		neighbourhood: make(map[chord.ID]chord.NodeRef, len(ft.neighbourhood)),
+	}
+	for i, fte := range ft.entries {
+		entry := *fte.(*fingerTableEntry)
+		c.entries[i] = &entry
+	}
+	for id, ref := range ft.neighbourhood {
+		c.neighbourhood[id] = ref
+	}
+	return c
+}
+
 func (ft *fingerTable) String() string {
 	var b bytes.Buffer
 	for _, fte := range ft.entries {
@@ -142,7 +160,7 @@ func (ft *fingerTable) AsProtobufFT() *pb.FingerTable {
 	return &pbft
 }
 
-func newFingerTable(initNode chord.Node, m chord.Rank) chord.FingerTable {
+func newFingerTable(initNode chord.NodeRef, m chord.Rank) *fingerTable {
 	ft := fingerTable{
 		m:             m,
 		ownerID:       initNode.GetID(),
@@ -0,0 +1,251 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"github.com/kevinjqiu/chordio/chord"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// defaultFixFingersWorkers is the number of concurrent lookups when fixing the fingers
+const defaultFixFingersWorkers = 4
+
+// FixFingersPolicy decides which finger table entries are re-resolved on every FixFingers
+type FixFingersPolicy string
+
+const (
+	// FixFingersAll re-resolves every entry
+	FixFingersAll FixFingersPolicy = "all"
+	// FixFingersRoundRobin re-resolves the next entry, cycling through the table
+	FixFingersRoundRobin FixFingersPolicy = "round-robin"
+	// FixFingersRandom re-resolves a random entry
+	FixFingersRandom FixFingersPolicy = "random"
+	// FixFingersAdaptive re-resolves the next entries, cycling through the table, twice as many
+	// as the last time if it changed any entry and half as many otherwise
+	FixFingersAdaptive FixFingersPolicy = "adaptive"
+)
+
+// ParseFixFingersPolicy parses the name of a policy, the empty string is FixFingersAll
+func ParseFixFingersPolicy(s string) (FixFingersPolicy, error) {
+	switch p := FixFingersPolicy(s); p {
+	case "":
+		return FixFingersAll, nil
+	case FixFingersAll, FixFingersRoundRobin, FixFingersRandom, FixFingersAdaptive:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown fix fingers policy %q", s)
+	}
+}
+
+// WithFixFingersPolicy makes the node fix its finger table entries following p, FixFingersAll by default
+func WithFixFingersPolicy(p FixFingersPolicy) LocalOption {
+	return func(n *localNode) {
+		n.fixFingers.policy = p
+	}
+}
+
+// WithFixFingersOnStabilize controls whether Stabilize fixes the fingers, which it does by default.
+// Disable it when FixFingers runs on its own schedule
+func WithFixFingersOnStabilize(enabled bool) LocalOption {
+	return func(n *localNode) {
+		n.fixFingers.onStabilize = enabled
+	}
+}
+
+// WithFixFingersWorkers bounds the number of concurrent lookups when fixing the fingers
+func WithFixFingersWorkers(k int) LocalOption {
+	return func(n *localNode) {
+		if k > 0 {
+			n.fixFingers.workers = k
+		}
+	}
+}
+
+// fixFingersState is where the incremental policies are in the finger table,
+// guarded by the mutex of the node
+type fixFingersState struct {
+	policy      FixFingersPolicy
+	onStabilize bool
+	workers     int
+	next        int
+	batch       int
+}
+
+func newFixFingersState(m int) *fixFingersState {
+	return &fixFingersState{
+		policy:      FixFingersAll,
+		onStabilize: true,
+		workers:     defaultFixFingersWorkers,
+		// a node fixes its whole table first
+		batch: m,
+	}
+}
+
+// entries returns the indices of the entries to fix out of m
+func (s *fixFingersState) entries(m int) []int {
+	var count int
+	switch s.policy {
+	case FixFingersRandom:
+		return []int{rand.Intn(m)}
+	case FixFingersRoundRobin:
+		count = 1
+	case FixFingersAdaptive:
+		count = s.batch
+	default:
+		count = m
+	}
+
+	indices := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		indices = append(indices, (s.next+i)%m)
+	}
+	s.next = (s.next + count) % m
+	return indices
+}
+
+// done adapts the batch to the churn seen by the last run
+func (s *fixFingersState) done(m, numChanges int) {
+	if s.policy != FixFingersAdaptive {
+		return
+	}
+	if numChanges > 0 {
+		s.batch *= 2
+		if s.batch > m {
+			s.batch = m
+		}
+	} else if s.batch > 1 {
+		s.batch /= 2
+	}
+}
+
+// fingerTask resolves the finger of an entry, succ is the successor of the entry start if it's already known
+type fingerTask struct {
+	i    int
+	succ chord.Node
+}
+
+// fingerResult is the resolved finger of an entry, succ is the successor of the entry start
+// which differs from the finger when it's picked by proximity
+type fingerResult struct {
+	i      int
+	succ   chord.Node
+	finger chord.Node
+	err    error
+}
+
+// coveringNode returns the node known to succeed the start of the i'th entry without a lookup:
+// if an earlier entry's successor is past that start, it's the successor of both
+// (the init_finger_table optimization of the Chord paper)
+func (n *localNode) coveringNode(i int, succs map[int]chord.Node) chord.Node {
+	start := n.GetFingerTable().GetEntry(i).GetStart()
+	for k := i - 1; k >= 0; k-- {
+		succ, ok := succs[k]
+		if !ok {
+			continue
+		}
+		if chord.NewInterval(n.m, n.id, succ.GetID()).Has(start) {
+			return succ
+		}
+		// the successors of the earlier entries are further behind
+		return nil
+	}
+	return nil
+}
+
+// resolveFinger finds the finger of the entry, looking up the successor of its start unless it's known
+func (n *localNode) resolveFinger(ctx context.Context, t fingerTask) fingerResult {
+	fte := n.GetFingerTable().GetEntry(t.i)
+	succ := t.succ
+	if succ == nil {
+		var err error
+		if succ, err = n.FindSuccessor(ctx, fte.GetStart()); err != nil {
+			return fingerResult{i: t.i, err: err}
+		}
+	}
+	finger := succ
+	// the successor must be exact, the other fingers can be any node of their interval
+	if t.i > 0 && n.proximity.enabled() {
+		finger = n.closestCandidate(ctx, fte, succ)
+	}
+	return fingerResult{i: t.i, succ: succ, finger: finger}
+}
+
+// resolveFingers resolves the fingers of the entries with up to workers lookups at a time.
+// The lookups run in waves so that the entries covered by the successors found by a wave
+// are resolved without a lookup, the first wave is only the first entry as it usually covers
+// the following ones. Returns the fingers resolved before the first error, if any
+func (n *localNode) resolveFingers(ctx context.Context, indices []int, workers int) (map[int]chord.Node, error) {
+	sort.Ints(indices)
+	succs := make(map[int]chord.Node, len(indices))
+	fingers := make(map[int]chord.Node, len(indices))
+
+	pending, waveSize := indices, 1
+	for len(pending) > 0 {
+		var (
+			wave    []fingerTask
+			rest    []int
+			lookups int
+		)
+		for _, i := range pending {
+			if covering := n.coveringNode(i, succs); covering != nil {
+				wave = append(wave, fingerTask{i: i, succ: covering})
+			} else if lookups < waveSize {
+				wave = append(wave, fingerTask{i: i})
+				lookups++
+			} else {
+				rest = append(rest, i)
+			}
+		}
+
+		var firstErr error
+		for _, r := range n.runFingerTasks(ctx, wave, workers) {
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+				}
+				continue
+			}
+			succs[r.i] = r.succ
+			fingers[r.i] = r.finger
+		}
+		if firstErr != nil {
+			return fingers, firstErr
+		}
+		pending, waveSize = rest, workers
+	}
+	return fingers, nil
+}
+
+// runFingerTasks runs the tasks on a pool of workers
+func (n *localNode) runFingerTasks(ctx context.Context, tasks []fingerTask, workers int) []fingerResult {
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	taskCh := make(chan fingerTask)
+	resultCh := make(chan fingerResult, len(tasks))
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for t := range taskCh {
+				resultCh <- n.resolveFinger(ctx, t)
+			}
+		}()
+	}
+	for _, t := range tasks {
+		taskCh <- t
+	}
+	close(taskCh)
+	wg.Wait()
+	close(resultCh)
+
+	results := make([]fingerResult, 0, len(tasks))
+	for r := range resultCh {
+		results = append(results, r)
+	}
+	return results
+}
@@ -0,0 +1,95 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFixFingersPolicy(t *testing.T) {
+	t.Run("all", func(t *testing.T) {
+		s := newFixFingersState(3)
+		assert.Equal(t, []int{0, 1, 2}, s.entries(3))
+		assert.Equal(t, []int{0, 1, 2}, s.entries(3))
+	})
+
+	t.Run("round-robin", func(t *testing.T) {
+		s := newFixFingersState(3)
+		s.policy = FixFingersRoundRobin
+		assert.Equal(t, []int{0}, s.entries(3))
+		assert.Equal(t, []int{1}, s.entries(3))
+		assert.Equal(t, []int{2}, s.entries(3))
+		assert.Equal(t, []int{0}, s.entries(3))
+	})
+
+	t.Run("random", func(t *testing.T) {
+		s := newFixFingersState(3)
+		s.policy = FixFingersRandom
+		for k := 0; k < 10; k++ {
+			entries := s.entries(3)
+			if assert.Len(t, entries, 1) {
+				assert.True(t, entries[0] >= 0 && entries[0] < 3)
+			}
+		}
+	})
+
+	t.Run("adaptive", func(t *testing.T) {
+		s := newFixFingersState(8)
+		s.policy = FixFingersAdaptive
+		assert.Len(t, s.entries(8), 8)
+
+		s.done(8, 0)
+		assert.Equal(t, []int{0, 1, 2, 3}, s.entries(8))
+		s.done(8, 0)
+		assert.Equal(t, []int{4, 5}, s.entries(8))
+		s.done(8, 0)
+		assert.Equal(t, []int{6}, s.entries(8))
+		s.done(8, 0)
+		assert.Equal(t, []int{7}, s.entries(8))
+
+		// churn widens the batch again
+		s.done(8, 1)
+		assert.Equal(t, []int{0, 1}, s.entries(8))
+	})
+
+	t.Run("entries covered by the successor of an earlier entry need no lookup", func(t *testing.T) {
+		ln, _ := NewLocal(0, "", 3)
+		n := ln.(*localNode)
+
+		// the starts are 1, 2 and 4
+		succs := map[int]chord.Node{0: &remoteNode{id: 3}}
+		assert.Equal(t, chord.ID(3), n.coveringNode(1, succs).GetID())
+		assert.Nil(t, n.coveringNode(2, succs))
+
+		// the successor wrapped around to the node itself, nothing's in between
+		succs = map[int]chord.Node{1: n}
+		assert.Equal(t, chord.ID(0), n.coveringNode(2, succs).GetID())
+		assert.Nil(t, n.coveringNode(0, succs))
+	})
+
+	t.Run("a lone node resolves all its fingers to itself", func(t *testing.T) {
+		ln, _ := NewLocal(0, "", 5)
+		n := ln.(*localNode)
+
+		fingers, err := n.resolveFingers(context.Background(), []int{4, 0, 1, 2, 3}, 2)
+		assert.Nil(t, err)
+		assert.Len(t, fingers, 5)
+		for _, f := range fingers {
+			assert.Equal(t, chord.ID(0), f.GetID())
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		p, err := ParseFixFingersPolicy("")
+		assert.Nil(t, err)
+		assert.Equal(t, FixFingersAll, p)
+
+		p, err = ParseFixFingersPolicy("adaptive")
+		assert.Nil(t, err)
+		assert.Equal(t, FixFingersAdaptive, p)
+
+		_, err = ParseFixFingersPolicy("sometimes")
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"sync"
+)
+
+// rangeHooks are the callbacks registered for the ownership changes of a local node
+type rangeHooks struct {
+	mu       *sync.Mutex
+	acquired []func(chord.Interval)
+	released []func(chord.Interval)
+	handOver []func(context.Context, chord.Interval, chord.NodeRef) error
+}
+
+func (h *rangeHooks) onAcquired(f func(chord.Interval)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.acquired = append(h.acquired, f)
+}
+
+func (h *rangeHooks) onReleased(f func(chord.Interval)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.released = append(h.released, f)
+}
+
+func (h *rangeHooks) onHandOver(f func(context.Context, chord.Interval, chord.NodeRef) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handOver = append(h.handOver, f)
+}
+
+func (h *rangeHooks) runAcquired(iv chord.Interval) {
+	h.mu.Lock()
+	hooks := h.acquired
+	h.mu.Unlock()
+	for _, f := range hooks {
+		f(iv)
+	}
+}
+
+func (h *rangeHooks) runReleased(iv chord.Interval) {
+	h.mu.Lock()
+	hooks := h.released
+	h.mu.Unlock()
+	for _, f := range hooks {
+		f(iv)
+	}
+}
+
+// runHandOver runs every hand over hook, even if one of them fails. Returns the first error
+func (h *rangeHooks) runHandOver(ctx context.Context, iv chord.Interval, to chord.NodeRef) error {
+	h.mu.Lock()
+	hooks := h.handOver
+	h.mu.Unlock()
+	var firstErr error
+	for _, f := range hooks {
+		if err := f(ctx, iv, to); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func newRangeHooks() *rangeHooks {
+	return &rangeHooks{
+		mu: new(sync.Mutex),
+	}
+}
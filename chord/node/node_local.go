@@ -5,27 +5,41 @@ import (
 	"fmt"
 	"github.com/kevinjqiu/chordio/attrs"
 	"sync"
+	"sync/atomic"
 
 	"github.com/kevinjqiu/chordio/chord"
 	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/telemetry"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"go.opentelemetry.io/otel/api/global"
 	"go.opentelemetry.io/otel/api/trace"
 )
 
 type localNode struct {
 	trace.Tracer
-	mu       *sync.Mutex
-	id       chord.ID
-	bind     string
-	predNode chord.NodeRef
-	m        chord.Rank
-	ft       chord.FingerTable
+	// mu serializes the writers of the state and guards the fix fingers state
+	mu *sync.Mutex
+	// state holds the current *nodeState
+	state      atomic.Value
+	id         chord.ID
+	bind       string
+	m          chord.Rank
+	events     *eventBroker
+	hooks      *rangeHooks
+	proximity  *proximity
+	fixFingers *fixFingersState
+	// succListLength is the number of successors tracked to fail over to
+	succListLength int
+	dialer         *Dialer
+	// stabilizedSucc and stabilizedPred are the neighbours as of the last stabilization, guarded by mu,
+	// so that the changes notified by other nodes in between are counted by the next one
+	stabilizedSucc chord.NodeRef
+	stabilizedPred chord.NodeRef
 }
 
+// GetFingerTable returns a snapshot of the finger table, it must not be modified
 func (n *localNode) GetFingerTable() chord.FingerTable {
-	return n.ft
+	return n.snapshot().ft
 }
 
 func (n *localNode) GetRank() chord.Rank {
@@ -41,21 +55,67 @@ func (n *localNode) SetPredNode(ctx context.Context, pn chord.NodeRef) error {
 	defer span.End()
 
 	n.mu.Lock()
-	defer n.mu.Unlock()
-	n.predNode = pn
+	s := n.snapshot()
+	oldPred := s.predNode
+	n.state.Store(s.withPredNode(pn))
+	n.mu.Unlock()
+
+	if sameNode(oldPred, pn) {
+		return nil
+	}
+	n.events.publish(chord.Event{Type: chord.EventPredecessorChanged, Old: oldPred, New: pn})
+	n.ownershipChanged(oldPred, pn)
 	return nil
 }
 
+// ownershipChanged tells the subscribers and hooks about the key ranges
+// acquired and released when the predecessor changes from oldPred to newPred
+func (n *localNode) ownershipChanged(oldPred, newPred chord.NodeRef) {
+	acquired, lost := chord.OwnershipChange(n.m, n.id, oldPred, newPred)
+	if lost != nil {
+		n.events.publish(chord.Event{Type: chord.EventRangeLost, Range: *lost})
+		n.hooks.runReleased(*lost)
+	}
+	if acquired != nil {
+		n.events.publish(chord.Event{Type: chord.EventRangeAcquired, Range: *acquired})
+		n.hooks.runAcquired(*acquired)
+	}
+}
+
+func (n *localNode) OnRangeAcquired(f func(iv chord.Interval)) {
+	n.hooks.onAcquired(f)
+}
+
+func (n *localNode) OnRangeReleased(f func(iv chord.Interval)) {
+	n.hooks.onReleased(f)
+}
+
+func (n *localNode) OnHandOver(f func(ctx context.Context, iv chord.Interval, to chord.NodeRef) error) {
+	n.hooks.onHandOver(f)
+}
+
 func (n *localNode) SetSuccNode(ctx context.Context, sn chord.NodeRef) error {
 	_, span := n.Start(ctx, "localNode.SetSuccNode", trace.WithAttributes(attrs.Node("succ", sn)))
 	defer span.End()
 
 	n.mu.Lock()
-	defer n.mu.Unlock()
-	n.ft.SetNodeAtEntry(0, sn)
+	s := n.snapshot()
+	oldSucc := s.ft.GetEntry(0).GetNode()
+	n.state.Store(s.withFingers(func(ft *fingerTable) {
+		ft.SetNodeAtEntry(0, sn)
+	}))
+	n.mu.Unlock()
+
+	if !sameNode(oldSucc, sn) {
+		n.events.publish(chord.Event{Type: chord.EventSuccessorChanged, Old: oldSucc, New: sn})
+	}
 	return nil
 }
 
+func (n *localNode) Subscribe() (<-chan chord.Event, func()) {
+	return n.events.subscribe()
+}
+
 func (n *localNode) GetID() chord.ID {
 	return n.id
 }
@@ -72,7 +132,9 @@ func (n *localNode) AsProtobufNode() *pb.Node {
 		Succ: nil,
 	}
 
-	predNode := n.GetPredNode()
+	// both neighbours come from the same snapshot
+	s := n.snapshot()
+	predNode := s.predNode
 	if predNode != nil {
 		pbn.Pred = &pb.Node{
 			Id:   uint64(predNode.GetID()),
@@ -80,7 +142,7 @@ func (n *localNode) AsProtobufNode() *pb.Node {
 		}
 	}
 
-	succNode := n.GetSuccNode()
+	succNode := s.ft.GetEntry(0).GetNode()
 	if succNode != nil {
 		pbn.Succ = &pb.Node{
 			Id:   uint64(succNode.GetID()),
@@ -91,27 +153,30 @@ func (n *localNode) AsProtobufNode() *pb.Node {
 }
 
 func (n *localNode) GetPredNode() chord.NodeRef {
-	return n.predNode
+	return n.snapshot().predNode
 }
 
 func (n *localNode) GetSuccNode() chord.NodeRef {
-	return n.ft.GetEntry(0).GetNode()
+	return n.snapshot().ft.GetEntry(0).GetNode()
 }
 
 func (n *localNode) FindPredecessor(ctx context.Context, id chord.ID) (chord.Node, error) {
 	ctx, span := n.Start(ctx, "localNode.FindPredecessor")
 	defer span.End()
 
-	var (
-		n_  chord.Node = n
-		err error
-	)
+	var n_ chord.Node = n
 	for {
 		interval := chord.NewInterval(n.m, n_.GetID(), n_.GetSuccNode().GetID(), chord.WithLeftOpen, chord.WithRightClosed)
 		if !interval.Has(id) {
-			if n_, err = n_.ClosestPrecedingFinger(ctx, id); err != nil {
+			next, err := n_.ClosestPrecedingFinger(ctx, id)
+			if err != nil {
 				return nil, err
 			}
+			if next.GetID() == n_.GetID() {
+				// none of its fingers, the successor included, can be reached until it stabilizes
+				return nil, errLookupStuck(n_)
+			}
+			n_ = next
 		} else {
 			break
 		}
@@ -138,7 +203,7 @@ func (n *localNode) FindSuccessor(ctx context.Context, id chord.ID) (chord.Node,
 		return n, nil
 	}
 
-	return NewRemote(ctx, succNode.GetBind())
+	return n.dialer.NewRemote(ctx, succNode.GetBind())
 }
 
 func (n *localNode) ClosestPrecedingFinger(ctx context.Context, id chord.ID) (chord.Node, error) {
@@ -147,13 +212,14 @@ func (n *localNode) ClosestPrecedingFinger(ctx context.Context, id chord.ID) (ch
 	// nb: int cast here is IMPORTANT!
 	// because n.m is of type uint32
 	// i >= 0 is always going to be true
+	ft := n.GetFingerTable()
 	for i := int(n.m) - 1; i >= 0; i-- {
-		fte := n.ft.GetEntry(i)
+		fte := ft.GetEntry(i)
 		interval := chord.NewInterval(n.m, n.id, id, chord.WithLeftOpen, chord.WithRightOpen)
 		span.AddEvent(ctx, fmt.Sprintf("i=%d,interval=%s, fte=%s", i, interval, fte))
 		if interval.Has(fte.GetNode().GetID()) {
 			span.AddEvent(ctx, fmt.Sprintf("node %s is in the interval %s", fte.GetNode(), interval))
-			node, ok := n.ft.GetNodeByID(fte.GetNode().GetID())
+			node, ok := ft.GetNodeByID(fte.GetNode().GetID())
 			if !ok {
 				err := errNodeNotFound(fte.GetNode().GetID())
 				span.RecordError(ctx, err)
@@ -163,9 +229,14 @@ func (n *localNode) ClosestPrecedingFinger(ctx context.Context, id chord.ID) (ch
 			span.AddEvent(ctx, fmt.Sprintf("ClosestPrecedingFinger is: %s", node.String()))
 			if node.GetID() == n.id {
 				return NewLocal(node.GetID(), node.GetBind(), n.m)
-			} else {
-				return NewRemote(ctx, node.GetBind())
 			}
+			remote, err := n.dialer.NewRemote(ctx, node.GetBind())
+			if err != nil {
+				// the finger may have failed, a closer one still makes progress
+				span.RecordError(ctx, err)
+				continue
+			}
+			return remote, nil
 		}
 	}
 	span.AddEvent(ctx, fmt.Sprintf("ClosestPrecedingFinger is the local node: %s", n.String()))
@@ -176,7 +247,7 @@ func (n *localNode) Join(ctx context.Context, introducerNode chord.RemoteNode) e
 	ctx, span := n.Start(ctx, "localNode.Join", trace.WithAttributes(attrs.Node("introducer", introducerNode)))
 	defer span.End()
 
-	span.AddEvent(ctx, fmt.Sprintf("before updating FT: %s", n.ft.String()))
+	span.AddEvent(ctx, fmt.Sprintf("before updating FT: %s", n.GetFingerTable().String()))
 
 	if err := n.SetPredNode(ctx, nil); err != nil {
 		span.RecordError(ctx, err)
@@ -192,15 +263,63 @@ func (n *localNode) Join(ctx context.Context, introducerNode chord.RemoteNode) e
 		span.RecordError(ctx, err)
 		return err
 	}
+	n.events.publish(chord.Event{Type: chord.EventJoined, New: succNode})
 	return nil
 }
 
+func (n *localNode) Leave(ctx context.Context) error {
+	ctx, span := n.Start(ctx, "localNode.Leave")
+	defer span.End()
+
+	pred := n.GetPredNode()
+	err := n.handOver(ctx, pred)
+	if err != nil {
+		span.RecordError(ctx, err)
+	}
+	// the node leaves either way
+	n.proximity.close()
+	n.ownershipChanged(pred, nil)
+	n.events.publish(chord.Event{Type: chord.EventLeft, Old: n})
+	n.events.close()
+	return err
+}
+
+// handOver links the successor and the predecessor to each other, then hands the owned range over to the successor
+func (n *localNode) handOver(ctx context.Context, pred chord.NodeRef) error {
+	if pred == nil || n.GetSuccNode().GetID() == n.id {
+		// the owned range is unknown, or there's no other node to take it over
+		return nil
+	}
+	succ, err := n.liveSuccessor(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to reach the successor")
+	}
+	if succ.GetID() == n.id {
+		return nil
+	}
+	if err := succ.SetPredNode(ctx, pred); err != nil {
+		return errors.Wrapf(err, "unable to set the predecessor of %s", succ)
+	}
+	if pred.GetID() != succ.GetID() {
+		predNode, err := n.dialer.NewRemote(ctx, pred.GetBind())
+		if err != nil {
+			return errors.Wrap(err, "unable to reach the predecessor")
+		}
+		if err := predNode.SetSuccNode(ctx, succ); err != nil {
+			return errors.Wrapf(err, "unable to set the successor of %s", predNode)
+		}
+	}
+	owned := chord.NewInterval(n.m, pred.GetID(), n.id, chord.WithLeftOpen, chord.WithRightClosed)
+	return n.hooks.runHandOver(ctx, owned, succ)
+}
+
 func (n *localNode) Notify(ctx context.Context, n_ chord.RemoteNode) error {
 	ctx, span := n.Start(ctx, "localNode.Notify", trace.WithAttributes(attrs.Node("n_", n_)))
 	defer span.End()
 
-	iv := chord.NewInterval(n.m, n.GetPredNode().GetID(), n.GetID(), chord.WithLeftClosed, chord.WithRightOpen)
-	if n.GetPredNode() == nil || iv.Has(n_.GetID()) {
+	pred := n.GetPredNode()
+	// a failed predecessor is replaced by whichever node notifies us next
+	if pred == nil || chord.NewInterval(n.m, pred.GetID(), n.GetID(), chord.WithLeftClosed, chord.WithRightOpen).Has(n_.GetID()) || !n.isAlive(ctx, pred) {
 		if err := n.SetPredNode(ctx, n_); err != nil {
 			span.RecordError(ctx, err)
 			return errors.Wrap(err, "unable to set predecessor to the remote node")
@@ -221,31 +340,32 @@ func (n *localNode) Stabilize(ctx context.Context) (int, error) {
 	defer span.End()
 
 	// TODO: do not use remote node if the node is local
-	succ, err := NewRemote(ctx, n.GetSuccNode().GetBind())
+	succ, err := n.liveSuccessor(ctx)
 	if err != nil {
 		span.RecordError(ctx, err)
 		return numChanges, err
 	}
 	x := succ.GetPredNode()
-	iv := chord.NewInterval(n.m, n.GetID(), n.GetSuccNode().GetID(), chord.WithLeftOpen, chord.WithRightOpen)
-	span.AddEvent(ctx, fmt.Sprintf("succ: %s, x: %s, iv: %s", succ.String(), x.String(), iv.String()))
-	if iv.Has(x.GetID()) {
-		if err := n.SetSuccNode(ctx, x); err != nil {
+	iv := chord.NewInterval(n.m, n.GetID(), succ.GetID(), chord.WithLeftOpen, chord.WithRightOpen)
+	span.AddEvent(ctx, fmt.Sprintf("succ: %s, x: %v, iv: %s", succ.String(), x, iv.String()))
+	// the successor may not know its predecessor yet, if it just joined
+	if x != nil && iv.Has(x.GetID()) {
+		// the predecessor of the successor may be a node that failed, which the successor doesn't know yet
+		if xRemote, err := n.dialer.NewRemote(ctx, x.GetBind()); err != nil {
 			span.RecordError(ctx, err)
-			return numChanges, err
-		}
-		xRemote, err := NewRemote(ctx, x.GetBind())
-		if err != nil {
-			span.RecordError(ctx, err)
-			return numChanges, err
-		}
-		if err := xRemote.SetPredNode(ctx, n); err != nil {
-			span.RecordError(ctx, err)
-			return numChanges, err
+		} else {
+			if err := n.SetSuccNode(ctx, x); err != nil {
+				span.RecordError(ctx, err)
+				return numChanges, err
+			}
+			if err := xRemote.SetPredNode(ctx, n); err != nil {
+				span.RecordError(ctx, err)
+				return numChanges, err
+			}
 		}
 	}
 
-	succNode, err := NewRemote(ctx, n.GetSuccNode().GetBind())
+	succNode, err := n.dialer.NewRemote(ctx, n.GetSuccNode().GetBind())
 	if err != nil {
 		span.RecordError(ctx, err)
 		return numChanges, err
@@ -255,62 +375,117 @@ func (n *localNode) Stabilize(ctx context.Context) (int, error) {
 		span.RecordError(ctx, err)
 		return numChanges, err
 	}
+	n.refreshSuccessorList(ctx, succNode)
+	numChanges = n.neighbourChanges()
 
-	numChanges, err = n.FixFingers(ctx)
-	if err != nil {
-		span.RecordError(ctx, err)
-		return numChanges, err
+	if n.fixFingers.onStabilize {
+		fingerChanges, err := n.FixFingers(ctx)
+		numChanges += fingerChanges
+		if err != nil {
+			span.RecordError(ctx, err)
+			return numChanges, err
+		}
 	}
+	n.events.publish(chord.Event{Type: chord.EventStabilized, NumChanges: numChanges})
 	return numChanges, nil
 }
 
+// neighbourChanges counts the changes of the successor and the predecessor since the last stabilization
+func (n *localNode) neighbourChanges() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var numChanges int
+	s := n.snapshot()
+	if succ := s.ft.GetEntry(0).GetNode(); !sameNode(n.stabilizedSucc, succ) {
+		numChanges++
+		n.stabilizedSucc = succ
+	}
+	if !sameNode(n.stabilizedPred, s.predNode) {
+		numChanges++
+		n.stabilizedPred = s.predNode
+	}
+	return numChanges
+}
+
 func (n *localNode) FixFingers(ctx context.Context) (int, error) {
 	ctx, span := n.Start(ctx, "localNode.FixFingers")
 	defer span.End()
 
+	m := n.m.AsInt()
 	n.mu.Lock()
-	defer n.mu.Unlock()
+	indices, workers := n.fixFingers.entries(m), n.fixFingers.workers
+	n.mu.Unlock()
 
-	//// i is the entry to fix
-	//i := rand.Int() % n.m.AsInt()
-	//
-	//succNode, err := n.FindSuccessor(ctx, n.GetFingerTable().GetEntry(i).Start)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//n.GetFingerTable().SetNodeAtEntry(i, succNode)
+	// the lookups run without holding the lock, the results are applied at once
+	fingers, err := n.resolveFingers(ctx, indices, workers)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
 	numChanges := 0
-	for i := 0; i < n.m.AsInt(); i++ {
-		succNode, err := n.FindSuccessor(ctx, n.GetFingerTable().GetEntry(i).GetStart())
-		if err != nil {
-			span.RecordError(ctx, err)
-			return numChanges, err
-		}
-		span.AddEvent(ctx, fmt.Sprintf("i=%d, fte[%d]=%s, succ=%s", i, i, n.GetFingerTable().GetEntry(i).String(), succNode.String()))
-		if n.GetFingerTable().GetEntry(i).GetNode().GetID() != succNode.GetID() {
-			numChanges++
-			n.GetFingerTable().SetNodeAtEntry(i, succNode)
+	var events []chord.Event
+	s := n.snapshot().withFingers(func(ft *fingerTable) {
+		for _, i := range indices {
+			succNode, ok := fingers[i]
+			if !ok {
+				continue
+			}
+			span.AddEvent(ctx, fmt.Sprintf("i=%d, fte[%d]=%s, succ=%s", i, i, ft.GetEntry(i).String(), succNode.String()))
+			oldNode := ft.GetEntry(i).GetNode()
+			if oldNode.GetID() != succNode.GetID() {
+				numChanges++
+				ft.SetNodeAtEntry(i, succNode)
+				events = append(events, chord.Event{Type: chord.EventFingerUpdated, FingerIndex: i, Old: oldNode, New: succNode})
+				if i == 0 {
+					events = append(events, chord.Event{Type: chord.EventSuccessorChanged, Old: oldNode, New: succNode})
+					// already counted as a finger change
+					n.stabilizedSucc = succNode
+				}
+			}
 		}
+	})
+	n.state.Store(s)
+	// the events are published once the changes are visible
+	for _, e := range events {
+		n.events.publish(e)
+	}
+
+	if err != nil {
+		span.RecordError(ctx, err)
+		return numChanges, err
 	}
-	n.GetFingerTable().PrettyPrint(nil)
+	n.fixFingers.done(m, numChanges)
+	n.proximity.prune(s.ft)
+	s.ft.PrettyPrint(nil)
 	return numChanges, nil
 }
 
-func NewLocal(id chord.ID, bind string, m chord.Rank) (chord.LocalNode, error) {
+func NewLocal(id chord.ID, bind string, m chord.Rank, opts ...LocalOption) (chord.LocalNode, error) {
 	localNodeRef := &nodeRef{
 		ID: id, Bind: bind,
 	}
 	localNode := &localNode{
-		Tracer:   global.Tracer(""),
-		mu:       new(sync.Mutex),
-		id:       id,
-		bind:     bind,
-		predNode: localNodeRef,
-		ft:       nil,
-		m:        m,
+		Tracer:         telemetry.Tracer(),
+		mu:             new(sync.Mutex),
+		id:             id,
+		bind:           bind,
+		m:              m,
+		events:         newEventBroker(),
+		hooks:          newRangeHooks(),
+		proximity:      newProximity(),
+		fixFingers:     newFixFingersState(m.AsInt()),
+		succListLength: defaultSuccessorListLength,
+		dialer:         NewDialer(nil, RPCPolicy{}),
+		stabilizedSucc: localNodeRef,
+		stabilizedPred: localNodeRef,
 	}
-	localNode.ft = newFingerTable(localNode, m)
+	for _, opt := range opts {
+		opt(localNode)
+	}
+	localNode.state.Store(&nodeState{
+		predNode: localNodeRef,
+		ft:       newFingerTable(localNodeRef, m),
+	})
 	return localNode, nil
 }
@@ -32,3 +32,24 @@ func AssignID(key []byte, m chord.Rank) chord.ID {
 	b := hasher.Sum(nil)
 	return chord.ID(binary.BigEndian.Uint64(b) % (chord.ID(2).Pow(m.AsInt())).AsU64())
 }
+
+// sameNode reports whether a and b refer to the same node, or are both unknown
+func sameNode(a, b chord.NodeRef) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.GetID() == b.GetID()
+}
+
+// sameNodes reports whether a and b refer to the same nodes in the same order
+func sameNodes(a, b []chord.NodeRef) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameNode(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
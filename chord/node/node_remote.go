@@ -6,11 +6,8 @@ import (
 	"github.com/kevinjqiu/chordio/attrs"
 	"github.com/kevinjqiu/chordio/chord"
 	"github.com/kevinjqiu/chordio/pb"
-	"github.com/kevinjqiu/chordio/telemetry"
 	"github.com/pkg/errors"
-	"go.opentelemetry.io/otel/api/global"
 	"go.opentelemetry.io/otel/api/trace"
-	"go.opentelemetry.io/otel/plugin/grpctrace"
 	"google.golang.org/grpc"
 )
 
@@ -24,14 +21,12 @@ type remoteNode struct {
 	bind     string
 	predNode *pb.Node
 	succNode *pb.Node
+	// dialer of the local node the proxy belongs to
+	dialer *Dialer
 }
 
 func (rn *remoteNode) getClient() (pb.ChordClient, closeFunc, error) {
-	conn, err := grpc.Dial(rn.bind,
-		grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(global.Tracer(telemetry.GetServiceName()))),
-		grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(global.Tracer(telemetry.GetServiceName()))),
-	)
+	conn, err := grpc.Dial(rn.bind, rn.dialer.dialOptions()...)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "unable to initiate grpc client for node: %v", rn.bind)
 	}
@@ -41,35 +36,27 @@ func (rn *remoteNode) getClient() (pb.ChordClient, closeFunc, error) {
 }
 
 func (rn *remoteNode) SetPredNode(ctx context.Context, n chord.NodeRef) error {
-	client, close, err := rn.getClient()
-	if err != nil {
+	return rn.call(ctx, "SetPredecessorNode", func(ctx context.Context, client pb.ChordClient) error {
+		_, err := client.SetPredecessorNode(ctx, &pb.SetPredecessorNodeRequest{
+			Node: &pb.Node{
+				Id:   n.GetID().AsU64(),
+				Bind: n.GetBind(),
+			},
+		})
 		return err
-	}
-	defer close()
-
-	_, err = client.SetPredecessorNode(ctx, &pb.SetPredecessorNodeRequest{
-		Node: &pb.Node{
-			Id:   n.GetID().AsU64(),
-			Bind: n.GetBind(),
-		},
 	})
-	return err
 }
 
 func (rn *remoteNode) SetSuccNode(ctx context.Context, n chord.NodeRef) error {
-	client, close, err := rn.getClient()
-	if err != nil {
+	return rn.call(ctx, "SetSuccessorNode", func(ctx context.Context, client pb.ChordClient) error {
+		_, err := client.SetSuccessorNode(ctx, &pb.SetSuccessorNodeRequest{
+			Node: &pb.Node{
+				Id:   n.GetID().AsU64(),
+				Bind: n.GetBind(),
+			},
+		})
 		return err
-	}
-	defer close()
-
-	_, err = client.SetSuccessorNode(ctx, &pb.SetSuccessorNodeRequest{
-		Node: &pb.Node{
-			Id:   n.GetID().AsU64(),
-			Bind: n.GetBind(),
-		},
 	})
-	return err
 }
 
 func (rn *remoteNode) String() string {
@@ -85,10 +72,17 @@ func (rn *remoteNode) GetBind() string {
 }
 
 func (rn *remoteNode) GetPredNode() chord.NodeRef {
+	// a node that just joined a ring doesn't know its predecessor yet
+	if rn.predNode == nil {
+		return nil
+	}
 	return &nodeRef{chord.ID(rn.predNode.Id), rn.predNode.Bind}
 }
 
 func (rn *remoteNode) GetSuccNode() chord.NodeRef {
+	if rn.succNode == nil {
+		return nil
+	}
 	return &nodeRef{chord.ID(rn.succNode.Id), rn.succNode.Bind}
 }
 
@@ -99,18 +93,16 @@ func (rn *remoteNode) FindPredecessor(ctx context.Context, id chord.ID) (chord.N
 		Id: uint64(id),
 	}
 
-	client, close, err := rn.getClient()
-	if err != nil {
-		return nil, err
-	}
-	defer close()
-
-	resp, err := client.FindPredecessor(ctx, &req)
+	var resp *pb.FindPredecessorResponse
+	err := rn.call(ctx, "FindPredecessor", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.FindPredecessor(ctx, &req)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return NewRemote(ctx, resp.Node.Bind)
+	return rn.dialer.NewRemote(ctx, resp.Node.Bind)
 }
 
 func (rn *remoteNode) FindSuccessor(ctx context.Context, id chord.ID) (chord.Node, error) {
@@ -121,40 +113,36 @@ func (rn *remoteNode) FindSuccessor(ctx context.Context, id chord.ID) (chord.Nod
 		Id: uint64(id),
 	}
 
-	client, close, err := rn.getClient()
-	if err != nil {
-		return nil, err
-	}
-	defer close()
-
-	resp, err := client.FindSuccessor(ctx, &req)
+	var resp *pb.FindSuccessorResponse
+	err := rn.call(ctx, "FindSuccessor", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.FindSuccessor(ctx, &req)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return NewRemote(ctx, resp.Node.Bind)
+	return rn.dialer.NewRemote(ctx, resp.Node.Bind)
 }
 
 func (rn *remoteNode) ClosestPrecedingFinger(ctx context.Context, id chord.ID) (chord.Node, error) {
 	ctx, span := rn.Start(ctx, "remoteNode.ClosestPrecedingFinger", trace.WithAttributes(attrs.ID("id", id)))
 	defer span.End()
 
-	client, close, err := rn.getClient()
-	if err != nil {
-		return nil, err
-	}
-	defer close()
-
 	req := pb.ClosestPrecedingFingerRequest{
 		Id: uint64(id),
 	}
 
-	resp, err := client.ClosestPrecedingFinger(ctx, &req)
+	var resp *pb.ClosestPrecedingFingerResponse
+	err := rn.call(ctx, "ClosestPrecedingFinger", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.ClosestPrecedingFinger(ctx, &req)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return NewRemote(ctx, resp.Node.Bind)
+	return rn.dialer.NewRemote(ctx, resp.Node.Bind)
 }
 
 func (rn *remoteNode) AsProtobufNode() *pb.Node {
@@ -192,25 +180,22 @@ func (rn *remoteNode) Notify(ctx context.Context, n_ chord.RemoteNode) error {
 		Node: n_.AsProtobufNode(),
 	}
 
-	client, close, err := rn.getClient()
+	err := rn.call(ctx, "Notify", func(ctx context.Context, client pb.ChordClient) error {
+		_, err := client.Notify(ctx, &req)
+		return err
+	})
 	if err != nil {
 		span.RecordError(ctx, err)
-		return err
 	}
-	defer close()
-
-	_, err = client.Notify(ctx, &req)
 	return err
 }
 
 func (rn *remoteNode) init(ctx context.Context) error {
-	client, close, err := rn.getClient()
-	if err != nil {
+	var resp *pb.GetNodeInfoResponse
+	err := rn.call(ctx, "GetNodeInfo", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.GetNodeInfo(ctx, &pb.GetNodeInfoRequest{})
 		return err
-	}
-	defer close()
-
-	resp, err := client.GetNodeInfo(ctx, &pb.GetNodeInfoRequest{})
+	})
 	if err != nil {
 		return err
 	}
@@ -220,14 +205,3 @@ func (rn *remoteNode) init(ctx context.Context) error {
 	rn.succNode = resp.Node.GetSucc()
 	return nil
 }
-
-func NewRemote(ctx context.Context, bind string) (chord.RemoteNode, error) {
-	rn := &remoteNode{
-		Tracer:  global.Tracer(""),
-		bind:    bind,
-	}
-	if err := rn.init(ctx); err != nil {
-		return nil, err
-	}
-	return rn, nil
-}
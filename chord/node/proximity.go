@@ -0,0 +1,213 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/telemetry"
+	"google.golang.org/grpc"
+	"sync"
+	"time"
+)
+
+const (
+	// rttSmoothing is the weight of a new RTT sample in the moving average
+	rttSmoothing = 0.25
+	// proximityHysteresis is how much closer than the current finger a candidate must be to replace it,
+	// so that the fingers don't flap with the noise of the RTT samples
+	proximityHysteresis = 0.8
+)
+
+// LocalOption customizes the local node
+type LocalOption func(n *localNode)
+
+// WithProximityCandidates makes the node consider up to k nodes for every finger but the successor,
+// the successor of the finger start and its own successors as long as they're in the finger interval,
+// and pick the one with the lowest round-trip time. Fingers are picked by ID only if k <= 1
+func WithProximityCandidates(k int) LocalOption {
+	return func(n *localNode) {
+		n.proximity.candidates = k
+	}
+}
+
+// proximity tracks the round-trip time to the nodes of the neighbourhood
+type proximity struct {
+	mu         sync.Mutex
+	candidates int
+	rtts       map[chord.ID]time.Duration
+	// conns are the connections the nodes are probed on, kept across the rounds
+	conns map[chord.ID]*grpc.ClientConn
+}
+
+func newProximity() *proximity {
+	return &proximity{rtts: make(map[chord.ID]time.Duration), conns: make(map[chord.ID]*grpc.ClientConn)}
+}
+
+func (p *proximity) enabled() bool {
+	return p.candidates > 1
+}
+
+// observe a sample of the RTT to the node, returns the smoothed RTT
+func (p *proximity) observe(id chord.ID, sample time.Duration) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rtt, ok := p.rtts[id]
+	if !ok {
+		rtt = sample
+	} else {
+		rtt = time.Duration(rttSmoothing*float64(sample) + (1-rttSmoothing)*float64(rtt))
+	}
+	p.rtts[id] = rtt
+	return rtt
+}
+
+// prune forgets the nodes which aren't in the neighbourhood anymore, and closes their connections
+func (p *proximity) prune(ft chord.FingerTable) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := range p.rtts {
+		if !ft.HasNode(id) {
+			delete(p.rtts, id)
+		}
+	}
+	for id, conn := range p.conns {
+		if !ft.HasNode(id) {
+			conn.Close()
+			delete(p.conns, id)
+		}
+	}
+}
+
+// conn returns the connection to the node, dialed by d and established if it's a new one
+func (p *proximity) conn(ctx context.Context, ref chord.NodeRef, d *Dialer) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	conn, ok := p.conns[ref.GetID()]
+	p.mu.Unlock()
+	if ok {
+		return conn, nil
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, d.policy.timeout("GetNodeInfo"))
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, ref.GetBind(), append(d.dialOptions(), grpc.WithBlock())...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.conns[ref.GetID()]; ok {
+		// dialed concurrently
+		conn.Close()
+		return existing, nil
+	}
+	p.conns[ref.GetID()] = conn
+	return conn, nil
+}
+
+// drop closes the connection to the node, the next probe dials it again
+func (p *proximity) drop(id chord.ID, conn *grpc.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[id] == conn {
+		delete(p.conns, id)
+	}
+	conn.Close()
+}
+
+// close closes all the connections
+func (p *proximity) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, id)
+	}
+}
+
+// probe measures the RTT to the node, returning it up to date. Only a GetNodeInfo call is timed,
+// on a connection established beforehand and kept for the next probes
+func (n *localNode) probe(ctx context.Context, ref chord.NodeRef) (chord.Node, time.Duration, error) {
+	conn, err := n.proximity.conn(ctx, ref, n.dialer)
+	if err != nil {
+		return nil, 0, err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, n.dialer.policy.timeout("GetNodeInfo"))
+	defer cancel()
+
+	start := time.Now()
+	resp, err := pb.NewChordClient(conn).GetNodeInfo(callCtx, &pb.GetNodeInfoRequest{})
+	sample := time.Since(start)
+	if err != nil {
+		n.proximity.drop(ref.GetID(), conn)
+		return nil, 0, err
+	}
+	node := &remoteNode{
+		Tracer:   telemetry.Tracer(),
+		id:       chord.ID(resp.Node.GetId()),
+		bind:     ref.GetBind(),
+		predNode: resp.Node.GetPred(),
+		succNode: resp.Node.GetSucc(),
+		dialer:   n.dialer,
+	}
+	return node, n.proximity.observe(node.GetID(), sample), nil
+}
+
+// candidate is a node which can be the finger of an entry
+type candidate struct {
+	node chord.Node
+	rtt  time.Duration
+}
+
+// closestCandidate picks the finger of the entry among succ, the successor of the entry start,
+// and the nodes following it in the entry interval, by round-trip time
+func (n *localNode) closestCandidate(ctx context.Context, fte chord.FingerTableEntry, succ chord.Node) chord.Node {
+	iv := fte.GetInterval()
+
+	var (
+		candidates []candidate
+		seen       = map[chord.ID]bool{}
+		next       = chord.NodeRef(succ)
+	)
+	for len(candidates) < n.proximity.candidates {
+		if next == nil || next.GetID() == n.id || seen[next.GetID()] || !iv.Has(next.GetID()) {
+			break
+		}
+		seen[next.GetID()] = true
+
+		node, rtt, err := n.probe(ctx, next)
+		if err != nil {
+			break
+		}
+		candidates = append(candidates, candidate{node, rtt})
+		next = node.GetSuccNode()
+	}
+
+	if best := pickClosest(candidates, fte.GetNode()); best != nil {
+		return best
+	}
+	return succ
+}
+
+// pickClosest picks the candidate with the lowest RTT, the current finger is kept
+// unless another candidate is clearly closer
+func pickClosest(candidates []candidate, current chord.NodeRef) chord.Node {
+	var best, kept *candidate
+	for i, c := range candidates {
+		if best == nil || c.rtt < best.rtt {
+			best = &candidates[i]
+		}
+		if current != nil && c.node.GetID() == current.GetID() {
+			kept = &candidates[i]
+		}
+	}
+
+	switch {
+	case best == nil:
+		return nil
+	case kept != nil && float64(best.rtt) > proximityHysteresis*float64(kept.rtt):
+		return kept.node
+	default:
+		return best.node
+	}
+}
@@ -0,0 +1,113 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/transport"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nodeInfoServer answers GetNodeInfo as node 5, counting the calls
+type nodeInfoServer struct {
+	pb.UnimplementedChordServer
+	calls int32
+}
+
+func (s *nodeInfoServer) GetNodeInfo(context.Context, *pb.GetNodeInfoRequest) (*pb.GetNodeInfoResponse, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return &pb.GetNodeInfoResponse{Node: &pb.Node{Id: 5, Bind: "inproc://probed", Succ: &pb.Node{Id: 6, Bind: "inproc://next"}}}, nil
+}
+
+// countingListener counts the connections it accepted
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestProximity(t *testing.T) {
+	newCandidate := func(id int, rtt time.Duration) candidate {
+		n, _ := NewLocal(chord.ID(id), "", 3)
+		return candidate{n, rtt}
+	}
+
+	t.Run("the RTT is smoothed", func(t *testing.T) {
+		p := newProximity()
+		assert.Equal(t, 100*time.Millisecond, p.observe(1, 100*time.Millisecond))
+		assert.Equal(t, 125*time.Millisecond, p.observe(1, 200*time.Millisecond))
+	})
+
+	t.Run("nodes out of the neighbourhood are forgotten", func(t *testing.T) {
+		n, _ := NewLocal(0, "", 3)
+		p := newProximity()
+		p.observe(0, time.Millisecond)
+		p.observe(1, time.Millisecond)
+		p.prune(n.GetFingerTable())
+		assert.Equal(t, map[chord.ID]time.Duration{0: time.Millisecond}, p.rtts)
+	})
+
+	t.Run("the closest candidate is picked", func(t *testing.T) {
+		candidates := []candidate{
+			newCandidate(4, 30*time.Millisecond),
+			newCandidate(5, 10*time.Millisecond),
+			newCandidate(6, 20*time.Millisecond),
+		}
+		assert.Equal(t, chord.ID(5), pickClosest(candidates, nil).GetID())
+	})
+
+	t.Run("the current finger is kept unless another candidate is clearly closer", func(t *testing.T) {
+		candidates := []candidate{
+			newCandidate(4, 11*time.Millisecond),
+			newCandidate(5, 10*time.Millisecond),
+		}
+		assert.Equal(t, chord.ID(4), pickClosest(candidates, &nodeRef{ID: 4}).GetID())
+
+		candidates[1].rtt = 5 * time.Millisecond
+		assert.Equal(t, chord.ID(5), pickClosest(candidates, &nodeRef{ID: 4}).GetID())
+	})
+
+	t.Run("no candidate", func(t *testing.T) {
+		assert.Nil(t, pickClosest(nil, nil))
+	})
+
+	t.Run("nodes are probed on a connection kept across the rounds", func(t *testing.T) {
+		lis, err := transport.Listen("inproc://probed")
+		assert.Nil(t, err)
+		counting := &countingListener{Listener: lis}
+		srv := &nodeInfoServer{}
+		s := grpc.NewServer()
+		pb.RegisterChordServer(s, srv)
+		go s.Serve(counting)
+		defer s.Stop()
+
+		local, _ := NewLocal(0, "inproc://local", 3)
+		n := local.(*localNode)
+		defer n.proximity.close()
+		for i := 0; i < 3; i++ {
+			node, rtt, err := n.probe(context.Background(), &nodeRef{ID: 5, Bind: "inproc://probed"})
+			assert.Nil(t, err)
+			assert.Equal(t, chord.ID(5), node.GetID())
+			assert.Equal(t, chord.ID(6), node.GetSuccNode().GetID())
+			assert.True(t, rtt > 0)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&counting.accepted))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&srv.calls))
+
+		// the connection of a node out of the neighbourhood is closed
+		n.proximity.prune(n.GetFingerTable())
+		assert.Empty(t, n.proximity.conns)
+	})
+}
@@ -0,0 +1,178 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryBudgetReserve is the number of retries a node that barely made any call can still make
+const retryBudgetReserve = 10
+
+// idempotentRPCs are the RPCs which can be retried without side effects
+var idempotentRPCs = map[string]bool{
+	"GetNodeInfo":            true,
+	"FindSuccessor":          true,
+	"ClosestPrecedingFinger": true,
+	"Get":                    true,
+	"GetReplica":             true,
+	"Replicate":              true,
+	"StoreHints":             true,
+	"GetUploadOffset":        true,
+	"PutBlock":               true,
+	"GetBlock":               true,
+	"GetMerkleNodes":         true,
+	"GetDigests":             true,
+}
+
+// RPCPolicy bounds the calls made to the remote nodes, the zero value of a field means its default
+type RPCPolicy struct {
+	// Timeout of every attempt of a call
+	Timeout time.Duration
+	// Timeouts overrides Timeout for the RPCs by name, e.g. FindSuccessor, regardless of the case
+	Timeouts map[string]time.Duration
+	// MaxAttempts of the idempotent RPCs, including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, multiplied by BackoffMultiplier
+	// for every further retry up to MaxBackoff
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryBudget is the ratio of retries to calls, so that retries don't pile up on an overloaded ring
+	RetryBudget float64
+}
+
+// DefaultRPCPolicy is used for the fields left unset
+var DefaultRPCPolicy = RPCPolicy{
+	Timeout:           5 * time.Second,
+	MaxAttempts:       3,
+	InitialBackoff:    100 * time.Millisecond,
+	MaxBackoff:        2 * time.Second,
+	BackoffMultiplier: 2,
+	RetryBudget:       0.1,
+}
+
+func (p RPCPolicy) withDefaults() RPCPolicy {
+	if p.Timeout == 0 {
+		p.Timeout = DefaultRPCPolicy.Timeout
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultRPCPolicy.MaxAttempts
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = DefaultRPCPolicy.InitialBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRPCPolicy.MaxBackoff
+	}
+	if p.BackoffMultiplier == 0 {
+		p.BackoffMultiplier = DefaultRPCPolicy.BackoffMultiplier
+	}
+	if p.RetryBudget == 0 {
+		p.RetryBudget = DefaultRPCPolicy.RetryBudget
+	}
+	// config files don't preserve the case of the keys
+	timeouts := make(map[string]time.Duration, len(p.Timeouts))
+	for method, t := range p.Timeouts {
+		timeouts[strings.ToLower(method)] = t
+	}
+	p.Timeouts = timeouts
+	return p
+}
+
+func (p RPCPolicy) timeout(method string) time.Duration {
+	if t, ok := p.Timeouts[strings.ToLower(method)]; ok && t > 0 {
+		return t
+	}
+	return p.Timeout
+}
+
+// backoff is the wait before the retry-th retry
+func (p RPCPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		backoff *= p.BackoffMultiplier
+		if backoff >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(backoff)
+}
+
+// retryBudget earns a fraction of a retry for every call and spends a whole one for every retry
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	ratio  float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{tokens: retryBudgetReserve, ratio: ratio}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetReserve {
+		b.tokens = retryBudgetReserve
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// IsTransient reports whether the call failed for a reason that may go away, e.g. the node is down
+func IsTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// call invokes the RPC named method on the remote node with a deadline,
+// retrying it with exponential backoff on transient errors if it's idempotent
+func (rn *remoteNode) call(ctx context.Context, method string, f func(ctx context.Context, client pb.ChordClient) error) error {
+	policy, budget := rn.dialer.policy, rn.dialer.budget
+
+	client, close, err := rn.getClient()
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	budget.deposit()
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.timeout(method))
+		err = f(attemptCtx, client)
+		cancel()
+
+		if err == nil || !idempotentRPCs[method] || !IsTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= policy.MaxAttempts || !budget.withdraw() {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/transport"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestRPCPolicy(t *testing.T) {
+	countAttempts := func(d *Dialer, method string, err error) int {
+		rn := &remoteNode{bind: "inproc://nowhere", dialer: d}
+		var attempts int
+		rn.call(context.Background(), method, func(ctx context.Context, client pb.ChordClient) error {
+			attempts++
+			return err
+		})
+		return attempts
+	}
+
+	t.Run("backoff grows exponentially up to the max", func(t *testing.T) {
+		p := RPCPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}.withDefaults()
+		assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+		assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+		assert.Equal(t, 800*time.Millisecond, p.backoff(4))
+		assert.Equal(t, time.Second, p.backoff(5))
+	})
+
+	t.Run("timeouts are looked up by RPC name regardless of the case", func(t *testing.T) {
+		p := RPCPolicy{Timeout: time.Second, Timeouts: map[string]time.Duration{"findsuccessor": time.Minute}}.withDefaults()
+		assert.Equal(t, time.Minute, p.timeout("FindSuccessor"))
+		assert.Equal(t, time.Second, p.timeout("Notify"))
+	})
+
+	t.Run("only idempotent RPCs are retried on transient errors", func(t *testing.T) {
+		d := NewDialer(nil, RPCPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+		assert.Equal(t, 3, countAttempts(d, "FindSuccessor", status.Error(codes.Unavailable, "")))
+		assert.Equal(t, 3, countAttempts(d, "GetNodeInfo", status.Error(codes.DeadlineExceeded, "")))
+		assert.Equal(t, 1, countAttempts(d, "Notify", status.Error(codes.Unavailable, "")))
+		assert.Equal(t, 1, countAttempts(d, "FindSuccessor", status.Error(codes.InvalidArgument, "")))
+		assert.Equal(t, 1, countAttempts(d, "FindSuccessor", nil))
+	})
+
+	t.Run("retries stop once the budget is spent", func(t *testing.T) {
+		d := NewDialer(nil, RPCPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, RetryBudget: 0.01})
+
+		var retries int
+		for i := 0; i < 20; i++ {
+			retries += countAttempts(d, "FindSuccessor", status.Error(codes.Unavailable, "")) - 1
+		}
+		assert.Equal(t, retryBudgetReserve, retries)
+
+		// the budget of the other nodes of the process is untouched
+		other := NewDialer(nil, RPCPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, RetryBudget: 0.01})
+		assert.Equal(t, 2, countAttempts(other, "FindSuccessor", status.Error(codes.Unavailable, "")))
+		assert.Equal(t, 1, countAttempts(d, "FindSuccessor", status.Error(codes.Unavailable, "")))
+	})
+
+	t.Run("calls to a hung peer time out", func(t *testing.T) {
+		d := NewDialer(nil, RPCPolicy{Timeout: 50 * time.Millisecond, MaxAttempts: 2, InitialBackoff: time.Millisecond})
+
+		// the listener never accepts the connections
+		lis, err := transport.Listen("inproc://hung")
+		assert.Nil(t, err)
+		defer lis.Close()
+
+		start := time.Now()
+		_, err = d.NewRemote(context.Background(), "inproc://hung")
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		assert.True(t, time.Since(start) < time.Second)
+	})
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"github.com/kevinjqiu/chordio/chord"
+)
+
+// nodeState is the routing state of the local node. It's never modified once published:
+// writers change a copy and swap it in, so readers get a consistent snapshot without locking
+type nodeState struct {
+	predNode chord.NodeRef
+	ft       *fingerTable
+	// succList is the successor followed by its own successors, as of the last stabilization
+	succList []chord.NodeRef
+}
+
+// withPredNode returns a copy of the state with pn as the predecessor
+func (s *nodeState) withPredNode(pn chord.NodeRef) *nodeState {
+	return &nodeState{predNode: pn, ft: s.ft, succList: s.succList}
+}
+
+// withFingers returns a copy of the state with the finger table changed by f
+func (s *nodeState) withFingers(f func(ft *fingerTable)) *nodeState {
+	ft := s.ft.clone()
+	f(ft)
+	return &nodeState{predNode: s.predNode, ft: ft, succList: s.succList}
+}
+
+// withSuccList returns a copy of the state with succs as the successor list
+func (s *nodeState) withSuccList(succs []chord.NodeRef) *nodeState {
+	return &nodeState{predNode: s.predNode, ft: s.ft, succList: succs}
+}
+
+// successors returns the successor list, it's only the successor if the list
+// doesn't start with it anymore since the successor changed after the last stabilization
+func (s *nodeState) successors() []chord.NodeRef {
+	succ := s.ft.GetEntry(0).GetNode()
+	if len(s.succList) == 0 || !sameNode(s.succList[0], succ) {
+		return []chord.NodeRef{succ}
+	}
+	return s.succList
+}
+
+// snapshot of the state, it must not be modified
+func (n *localNode) snapshot() *nodeState {
+	return n.state.Load().(*nodeState)
+}
@@ -0,0 +1,64 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	t.Run("snapshots aren't affected by later changes", func(t *testing.T) {
+		n, _ := NewLocal(0, "", 3)
+		ft := n.GetFingerTable()
+
+		assert.Nil(t, n.SetSuccNode(context.Background(), &nodeRef{ID: 1}))
+		assert.Nil(t, n.SetPredNode(context.Background(), &nodeRef{ID: 1}))
+
+		assert.Equal(t, chord.ID(0), ft.GetEntry(0).GetNode().GetID())
+		assert.False(t, ft.HasNode(1))
+		assert.Equal(t, chord.ID(1), n.GetFingerTable().GetEntry(0).GetNode().GetID())
+		assert.True(t, n.GetFingerTable().HasNode(1))
+	})
+
+	t.Run("the successor list is only the successor once the successor changed", func(t *testing.T) {
+		n, _ := NewLocal(0, "", 3, WithSuccessorListLength(3))
+		ln := n.(*localNode)
+		ln.state.Store(ln.snapshot().withSuccList([]chord.NodeRef{&nodeRef{ID: 0}, &nodeRef{ID: 1}, &nodeRef{ID: 2}}))
+		assert.Len(t, n.GetSuccessorList(), 3)
+
+		assert.Nil(t, n.SetSuccNode(context.Background(), &nodeRef{ID: 1}))
+		assert.Equal(t, []chord.NodeRef{&nodeRef{ID: 1}}, n.GetSuccessorList())
+	})
+
+	t.Run("reads and writes can run concurrently", func(t *testing.T) {
+		n, _ := NewLocal(0, "", 4)
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					n.SetPredNode(ctx, &nodeRef{ID: chord.ID(i % 16)})
+					n.SetSuccNode(ctx, &nodeRef{ID: chord.ID(i % 16)})
+					n.SetSuccNode(ctx, n)
+					n.FixFingers(ctx)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					n.GetPredNode()
+					n.GetSuccNode()
+					n.AsProtobufNode()
+					n.GetFingerTable().AsProtobufFT()
+					n.ClosestPrecedingFinger(ctx, chord.ID(i%16))
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
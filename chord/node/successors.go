@@ -0,0 +1,95 @@
+package node
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSuccessorListLength only tracks the successor
+const defaultSuccessorListLength = 1
+
+// WithSuccessorListLength makes the node track its next r successors, so that it can fail over
+// to the next live one when its successor fails. Only the successor is tracked by default
+func WithSuccessorListLength(r int) LocalOption {
+	return func(n *localNode) {
+		if r > 0 {
+			n.succListLength = r
+		}
+	}
+}
+
+func (n *localNode) GetSuccessorList() []chord.NodeRef {
+	return n.snapshot().successors()
+}
+
+// liveSuccessor returns the first node of the successor list that can be reached,
+// which becomes the successor if the successor failed
+func (n *localNode) liveSuccessor(ctx context.Context) (chord.RemoteNode, error) {
+	var lastErr error
+	for i, ref := range n.GetSuccessorList() {
+		succ, err := n.dialer.NewRemote(ctx, ref.GetBind())
+		if err != nil {
+			logrus.Warnf("successor %s is unreachable: %s", ref, err)
+			lastErr = err
+			continue
+		}
+		if i > 0 {
+			if err := n.SetSuccNode(ctx, succ); err != nil {
+				return nil, err
+			}
+		}
+		return succ, nil
+	}
+	return nil, lastErr
+}
+
+// refreshSuccessorList rebuilds the successor list from succ, asking every node in turn
+// for its successor. The list ends early at a node that can't be reached, or with the local node
+// once it wraps around.
+// Returns whether the list changed
+func (n *localNode) refreshSuccessorList(ctx context.Context, succ chord.Node) bool {
+	succs := []chord.NodeRef{&nodeRef{ID: succ.GetID(), Bind: succ.GetBind()}}
+	seen := map[chord.ID]bool{n.id: true, succ.GetID(): true}
+	for cur := succ; len(succs) < n.succListLength; {
+		next := cur.GetSuccNode()
+		if next != nil && next.GetID() == n.id && succ.GetID() != n.id {
+			// the local node ends a list that wraps around, telling the ring is smaller than the list
+			succs = append(succs, &nodeRef{ID: n.id, Bind: n.bind})
+			break
+		}
+		if next == nil || seen[next.GetID()] {
+			break
+		}
+		seen[next.GetID()] = true
+
+		remote, err := n.dialer.NewRemote(ctx, next.GetBind())
+		if err != nil {
+			break
+		}
+		succs = append(succs, &nodeRef{ID: remote.GetID(), Bind: remote.GetBind()})
+		cur = remote
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := n.snapshot()
+	if !sameNode(s.ft.GetEntry(0).GetNode(), succ) {
+		// the successor changed in the meantime, the next stabilization rebuilds the list
+		return false
+	}
+	if sameNodes(s.successors(), succs) {
+		return false
+	}
+	n.state.Store(s.withSuccList(succs))
+	return true
+}
+
+// isAlive reports whether the node can be reached
+func (n *localNode) isAlive(ctx context.Context, ref chord.NodeRef) bool {
+	if ref.GetID() == n.id {
+		return true
+	}
+	_, err := n.dialer.NewRemote(ctx, ref.GetBind())
+	return err == nil
+}
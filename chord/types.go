@@ -38,11 +38,34 @@ type (
 	LocalNode interface {
 		Node
 		GetFingerTable() FingerTable
+		// GetSuccessorList returns the successor followed by the next successors tracked by the node
+		GetSuccessorList() []NodeRef
 		Join(ctx context.Context, introducerNode RemoteNode) error
 		GetRank() Rank
 		// Stabilize the successor and finger table entries
-		// Returns the number of finger table entry changes
+		// Returns the number of changes of the successor, the predecessor and the finger table entries
 		Stabilize(ctx context.Context) (int, error)
+		// FixFingers re-resolves the finger table entries picked by the fix fingers policy
+		// Returns the number of finger table entry changes
+		FixFingers(ctx context.Context) (int, error)
+		// Leave the ring: the successor takes over the owned range and the predecessor links to it,
+		// the range is handed over by the OnHandOver hooks, then all event subscriptions end
+		Leave(ctx context.Context) error
+
+		// Subscribe to the events of the node
+		// The returned function cancels the subscription and closes the channel
+		Subscribe() (<-chan Event, func())
+
+		// OnRangeAcquired registers f to be called with the key range the node takes over
+		// when its predecessor changes. Hooks run synchronously and must not block.
+		OnRangeAcquired(f func(iv Interval))
+		// OnRangeReleased registers f to be called with the key range the node hands over
+		// when its predecessor changes, it joins a ring or it leaves.
+		// Hooks run synchronously and must not block.
+		OnRangeReleased(f func(iv Interval))
+		// OnHandOver registers f to be called with the owned key range and the successor taking it over
+		// when the node leaves. Unlike the range hooks, it may block: the node leaves once it returned
+		OnHandOver(f func(ctx context.Context, iv Interval, to NodeRef) error)
 	}
 
 	RemoteNode interface {
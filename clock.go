@@ -0,0 +1,43 @@
+package chordio
+
+import (
+	"sync"
+	"time"
+)
+
+// hybridClock issues the versions of the writes: the wall clock in nanoseconds, but always past
+// every version it issued or observed. The versions of a key keep increasing when its owner changes,
+// even if the clocks of the nodes drift apart
+type hybridClock struct {
+	mu   sync.Mutex
+	last uint64
+	now  func() time.Time
+}
+
+func newHybridClock() *hybridClock {
+	return &hybridClock{now: time.Now}
+}
+
+// next returns a version past the versions issued and observed so far, and past after
+func (c *hybridClock) next(after uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	version := uint64(c.now().UnixNano())
+	if version <= c.last {
+		version = c.last + 1
+	}
+	if version <= after {
+		version = after + 1
+	}
+	c.last = version
+	return version
+}
+
+// observe a version issued by another node
+func (c *hybridClock) observe(version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version > c.last {
+		c.last = version
+	}
+}
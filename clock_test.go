@@ -0,0 +1,31 @@
+package chordio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHybridClock(t *testing.T) {
+	wall := time.Unix(0, 100)
+	newClock := func() *hybridClock {
+		return &hybridClock{now: func() time.Time { return wall }}
+	}
+
+	t.Run("versions follow the wall clock", func(t *testing.T) {
+		assert.Equal(t, uint64(100), newClock().next(0))
+	})
+
+	t.Run("versions increase while the wall clock stands still", func(t *testing.T) {
+		c := newClock()
+		assert.Equal(t, uint64(100), c.next(0))
+		assert.Equal(t, uint64(101), c.next(0))
+	})
+
+	t.Run("versions are past the observed ones and the current version", func(t *testing.T) {
+		c := newClock()
+		c.observe(200)
+		assert.Equal(t, uint64(201), c.next(0))
+		assert.Equal(t, uint64(301), c.next(300))
+	})
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/kevinjqiu/chordio"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+func parseBlockID(s string) ([]byte, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid block ID %q", s)
+	}
+	return id, nil
+}
+
+func newPutBlockCommand() *cobra.Command {
+	var flags kvFlags
+	cmd := &cobra.Command{
+		Use:          "put-block <file>",
+		Short:        "store the content of a file as an immutable block, addressed by its SHA-256",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "put-block",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			resp, err := chordClient.PutBlock(ctx, &pb.PutBlockRequest{Data: data, Consistency: consistency})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "stored at %d on node %d@%s\n", resp.Position, resp.Owner.GetId(), resp.Owner.GetBind())
+			fmt.Println(hex.EncodeToString(resp.Id))
+			return nil
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
+
+func newGetBlockCommand() *cobra.Command {
+	var flags kvFlags
+	cmd := &cobra.Command{
+		Use:          "get-block <id>",
+		Short:        "write the data of a block to stdout",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+			id, err := parseBlockID(args[0])
+			if err != nil {
+				return err
+			}
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "get-block",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			resp, err := chordClient.GetBlock(ctx, &pb.GetBlockRequest{Id: id, Consistency: consistency})
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(resp.Data)
+			return err
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
+
+func newPutFileCommand() *cobra.Command {
+	var flags kvFlags
+	cmd := &cobra.Command{
+		Use:          "put-file <file>",
+		Short:        "store a file as blocks spread over the ring, and print the ID of their manifest",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "put-file",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			id, err := chordio.PutFile(ctx, chordClient, f, consistency)
+			if err != nil {
+				return err
+			}
+			fmt.Println(hex.EncodeToString(id))
+			return nil
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
+
+func newGetFileCommand() *cobra.Command {
+	var flags kvFlags
+	cmd := &cobra.Command{
+		Use:          "get-file <id> <file>",
+		Short:        "write the file addressed by the ID of its manifest",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+			id, err := parseBlockID(args[0])
+			if err != nil {
+				return err
+			}
+			f, err := os.Create(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "get-file",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			return chordio.GetFile(ctx, chordClient, id, f, consistency)
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
@@ -4,9 +4,9 @@ import (
 	"github.com/kevinjqiu/chordio/cmd/common"
 	"github.com/kevinjqiu/chordio/pb"
 	"github.com/kevinjqiu/chordio/telemetry"
+	"github.com/kevinjqiu/chordio/transport"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"go.opentelemetry.io/otel/api/global"
 	"go.opentelemetry.io/otel/plugin/grpctrace"
 	"google.golang.org/grpc"
 	"os"
@@ -24,12 +24,15 @@ func NewClientCommand() *cobra.Command {
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			var err error
 
-			tcon, err := common.GetTelemetryConfig(cmd.Parent())
+			tcon, err := common.GetTelemetryConfig(cmd)
 			if err != nil {
 				return err
 			}
+			if tcon.ServiceName == "" {
+				tcon.ServiceName = "chordio/client"
+			}
 
-			flushFunc, err = telemetry.Init("chordio/client", tcon)
+			flushFunc, err = telemetry.Init(tcon)
 			if err != nil {
 				return err
 			}
@@ -39,11 +42,25 @@ func NewClientCommand() *cobra.Command {
 				logrus.Fatal("CHORDIO_URL environment variable must be set")
 			}
 
+			tlsConfig, err := common.GetTLSConfig(cmd)
+			if err != nil {
+				return err
+			}
+			transportOption := grpc.WithInsecure()
+			if tlsConfig.Enabled() || tlsConfig.CAFile != "" {
+				creds, err := tlsConfig.ClientCredentials()
+				if err != nil {
+					return err
+				}
+				transportOption = grpc.WithTransportCredentials(creds)
+			}
+
 			conn, err := grpc.Dial(
 				chordioURL,
-				grpc.WithInsecure(),
-				grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(global.Tracer("chordio/client"))),
-				grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(global.Tracer("chordio/client"))),
+				transportOption,
+				grpc.WithContextDialer(transport.Dial),
+				grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(telemetry.Tracer())),
+				grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(telemetry.Tracer())),
 			)
 			if err != nil {
 				return err
@@ -56,5 +73,15 @@ func NewClientCommand() *cobra.Command {
 	cmd.AddCommand(newStatusCommand())
 	cmd.AddCommand(newJoinCommand())
 	cmd.AddCommand(newStabilizeCommand())
+	cmd.AddCommand(newWatchCommand())
+	cmd.AddCommand(newPutCommand())
+	cmd.AddCommand(newGetCommand())
+	cmd.AddCommand(newDeleteCommand())
+	cmd.AddCommand(newUploadCommand())
+	cmd.AddCommand(newDownloadCommand())
+	cmd.AddCommand(newPutBlockCommand())
+	cmd.AddCommand(newGetBlockCommand())
+	cmd.AddCommand(newPutFileCommand())
+	cmd.AddCommand(newGetFileCommand())
 	return cmd
 }
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/kevinjqiu/chordio"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"time"
+)
+
+type kvFlags struct {
+	consistency string
+}
+
+func (f *kvFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.consistency, "consistency", "one", "number of replicas to wait for: one, quorum or all")
+}
+
+func newPutCommand() *cobra.Command {
+	var (
+		flags           kvFlags
+		expectedVersion uint64
+		ttl             time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:          "put <key> <value>",
+		Short:        "store the value of a key in the ring",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "put",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			resp, err := chordClient.Put(ctx, &pb.PutRequest{
+				Key:             args[0],
+				Value:           []byte(args[1]),
+				Consistency:     consistency,
+				Conditional:     cmd.Flags().Changed("expected-version"),
+				ExpectedVersion: expectedVersion,
+				TtlMillis:       ttl.Milliseconds(),
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("stored version %d on node %d@%s\n", resp.Version, resp.Owner.GetId(), resp.Owner.GetBind())
+			return nil
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().Uint64Var(&expectedVersion, "expected-version", 0, "only store the value if the key is at this version, 0 if it must not exist")
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "remove the key once this much time passed, never if 0")
+	return cmd
+}
+
+func newGetCommand() *cobra.Command {
+	var flags kvFlags
+	cmd := &cobra.Command{
+		Use:          "get <key>",
+		Short:        "read the value of a key from the ring",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "get",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			resp, err := chordClient.Get(ctx, &pb.GetRequest{Key: args[0], Consistency: consistency})
+			if err != nil {
+				return err
+			}
+			if resp.Divergent {
+				fmt.Fprintf(os.Stderr, "the replicas disagree, showing the newest version %d\n", resp.Version)
+			}
+			if !resp.Found {
+				return errors.Errorf("key %q not found on node %d@%s", args[0], resp.Owner.GetId(), resp.Owner.GetBind())
+			}
+			// the version goes to stderr, so that the value can be piped as is
+			fmt.Fprintf(os.Stderr, "version %d\n", resp.Version)
+			fmt.Println(string(resp.Value))
+			return nil
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
+
+func newDeleteCommand() *cobra.Command {
+	var (
+		flags           kvFlags
+		expectedVersion uint64
+	)
+	cmd := &cobra.Command{
+		Use:          "delete <key>",
+		Short:        "delete a key from the ring",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "delete",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			resp, err := chordClient.Delete(ctx, &pb.DeleteRequest{
+				Key:             args[0],
+				Consistency:     consistency,
+				Conditional:     cmd.Flags().Changed("expected-version"),
+				ExpectedVersion: expectedVersion,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("deleted at version %d on node %d@%s\n", resp.Version, resp.Owner.GetId(), resp.Owner.GetBind())
+			return nil
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().Uint64Var(&expectedVersion, "expected-version", 0, "only delete the key if it's at this version")
+	return cmd
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/kevinjqiu/chordio"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"os"
+	"time"
+)
+
+// uploadChunkSize is the size of the chunks of the uploaded files
+const uploadChunkSize = 1 << 20
+
+// hashFile returns the SHA-256 of the content of the file
+func hashFile(f *os.File) ([]byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func newUploadCommand() *cobra.Command {
+	var (
+		flags kvFlags
+		ttl   time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:          "upload <key> <file>",
+		Short:        "store the content of a file as the value of a key in the ring, resuming an interrupted upload",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			sum, err := hashFile(f)
+			if err != nil {
+				return err
+			}
+			// uploading the same file to the same key again resumes where the last upload stopped
+			id := sha256.Sum256(append([]byte(args[0]), sum...))
+			uploadID := hex.EncodeToString(id[:])
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "upload",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			offsetResp, err := chordClient.GetUploadOffset(ctx, &pb.UploadOffsetRequest{Key: args[0], UploadId: uploadID})
+			if err != nil {
+				return err
+			}
+			offset := int64(offsetResp.Offset)
+			if offset > 0 {
+				fmt.Fprintf(os.Stderr, "resuming from offset %d\n", offset)
+			}
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+
+			stream, err := chordClient.PutStream(ctx)
+			if err != nil {
+				return err
+			}
+			buf := make([]byte, uploadChunkSize)
+			for {
+				n, err := io.ReadFull(f, buf)
+				last := err == io.EOF || err == io.ErrUnexpectedEOF
+				if err != nil && !last {
+					return err
+				}
+				chunk := &pb.PutChunk{
+					Key:         args[0],
+					Consistency: consistency,
+					TtlMillis:   ttl.Milliseconds(),
+					UploadId:    uploadID,
+					Offset:      uint64(offset),
+					Data:        buf[:n],
+					Last:        last,
+				}
+				if last {
+					chunk.Sha256 = sum
+				}
+				if err := stream.Send(chunk); err != nil {
+					return errors.Wrap(err, "the upload was interrupted")
+				}
+				if last {
+					break
+				}
+				offset += int64(n)
+			}
+			resp, err := stream.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("stored version %d on node %d@%s\n", resp.Version, resp.Owner.GetId(), resp.Owner.GetBind())
+			return nil
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "remove the key once this much time passed, never if 0")
+	return cmd
+}
+
+func newDownloadCommand() *cobra.Command {
+	var (
+		flags  kvFlags
+		resume bool
+	)
+	cmd := &cobra.Command{
+		Use:          "download <key> <file>",
+		Short:        "write the value of a key in the ring to a file",
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			consistency, err := chordio.ParseConsistency(flags.consistency)
+			if err != nil {
+				return err
+			}
+
+			flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+			if resume {
+				flag = os.O_RDWR | os.O_CREATE
+			}
+			f, err := os.OpenFile(args[1], flag, 0644)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			offset, err := f.Seek(0, io.SeekEnd)
+			if err != nil {
+				return err
+			}
+			if offset > 0 {
+				fmt.Fprintf(os.Stderr, "resuming from offset %d\n", offset)
+			}
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "download",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			stream, err := chordClient.GetStream(ctx, &pb.GetStreamRequest{Key: args[0], Offset: uint64(offset), Consistency: consistency})
+			if err != nil {
+				return err
+			}
+			first, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			for chunk := first; ; {
+				if _, err := f.Write(chunk.Data); err != nil {
+					return err
+				}
+				if chunk, err = stream.Recv(); err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+			}
+
+			// a resumed download is only whole if the value didn't change in between
+			sum, err := hashFile(f)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(sum, first.Sha256) {
+				return errors.Errorf("the SHA-256 of %s doesn't match that of version %d, download it again without --resume", args[1], first.Version)
+			}
+			fmt.Fprintf(os.Stderr, "version %d, %d bytes from node %d@%s\n", first.Version, first.Size, first.Owner.GetId(), first.Owner.GetBind())
+			return nil
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().BoolVar(&resume, "resume", false, "resume an interrupted download from the size of the file")
+	return cmd
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"time"
+)
+
+func formatEvent(e *pb.Event) string {
+	ts := time.Unix(0, e.Timestamp).Format(time.StampMilli)
+	switch e.Type {
+	case pb.EventType_FINGER_UPDATED:
+		return fmt.Sprintf("%s %s i=%d %d -> %d", ts, e.Type, e.FingerIndex, e.Old.GetId(), e.New.GetId())
+	case pb.EventType_STABILIZED:
+		return fmt.Sprintf("%s %s changes=%d", ts, e.Type, e.NumChanges)
+	case pb.EventType_RANGE_ACQUIRED, pb.EventType_RANGE_LOST:
+		return fmt.Sprintf("%s %s (%d, %d]", ts, e.Type, e.Range.GetStart(), e.Range.GetEnd())
+	case pb.EventType_JOINED:
+		return fmt.Sprintf("%s %s succ=%d", ts, e.Type, e.New.GetId())
+	case pb.EventType_LEFT:
+		return fmt.Sprintf("%s %s", ts, e.Type)
+	default:
+		return fmt.Sprintf("%s %s %s -> %s", ts, e.Type, e.Old.String(), e.New.String())
+	}
+}
+
+func newWatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "watch",
+		Short:        "stream the events of the chord server",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer flushFunc()
+
+			md := metadata.Pairs(
+				"timestamp", time.Now().Format(time.StampNano),
+				"operation", "watch",
+			)
+			ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+			stream, err := chordClient.WatchEvents(ctx, &pb.WatchEventsRequest{})
+			if err != nil {
+				return err
+			}
+			for {
+				e, err := stream.Recv()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Println(formatEvent(e))
+			}
+		},
+	}
+	return cmd
+}
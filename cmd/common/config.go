@@ -0,0 +1,77 @@
+package common
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+)
+
+const envPrefix = "CHORDIO"
+
+// EnvVarName is the environment variable overriding the given flag,
+// e.g. tracing.otlp-endpoint is overridden by CHORDIO_TRACING_OTLP_ENDPOINT
+func EnvVarName(flag string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flag))
+}
+
+// NewViper layers the config file, the environment variables and the flags of the command,
+// in increasing order of precedence. bindings maps the config keys to the flags overriding them.
+func NewViper(cmd *cobra.Command, bindings map[string]string) (*viper.Viper, error) {
+	v := viper.New()
+
+	configFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, err
+	}
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.Wrapf(err, "unable to read config file %s", configFile)
+		}
+	}
+
+	for key, flagName := range bindings {
+		flag := cmd.Flags().Lookup(flagName)
+		if flag == nil {
+			return nil, fmt.Errorf("flag %s is not defined", flagName)
+		}
+		if err := v.BindPFlag(key, flag); err != nil {
+			return nil, err
+		}
+		if err := v.BindEnv(key, EnvVarName(flagName)); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// mergeKeyValues overlays the k=v pairs given in the environment and on the command line onto m
+func mergeKeyValues(cmd *cobra.Command, flagName string, m map[string]string) (map[string]string, error) {
+	if m == nil {
+		m = make(map[string]string)
+	}
+
+	if env := os.Getenv(EnvVarName(flagName)); env != "" {
+		for _, pair := range strings.Split(env, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%s must be of the form k=v,k=v: %s", EnvVarName(flagName), env)
+			}
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	if cmd.Flags().Changed(flagName) {
+		kvs, err := cmd.Flags().GetStringToString(flagName)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range kvs {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
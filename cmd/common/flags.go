@@ -7,19 +7,29 @@ import (
 
 type (
 	CommonFlags struct {
-		Loglevel string
-		Tracing  TracingConfig
-	}
-
-	TracingConfig struct {
-		Enabled            bool
-		JaegerCollectorURL string
+		Loglevel   string
+		ConfigFile string
 	}
 )
 
 func AddCommonPflags(cmd *cobra.Command, flags *CommonFlags) {
 	cmd.PersistentFlags().StringVarP(&flags.Loglevel, "loglevel", "l", "info", "log level")
-	cmd.PersistentFlags().BoolVarP(&flags.Tracing.Enabled, "tracing.enabled", "t", true, "enable opentracing")
-	cmd.PersistentFlags().StringVarP(&flags.Tracing.JaegerCollectorURL, "tracing.jaeger-collector-url", "r", telemetry.DefaultJaegerCollectorEndpoint, "jaeger collector URL")
+	cmd.PersistentFlags().StringVarP(&flags.ConfigFile, "config", "c", "", "config file (YAML, TOML or JSON)")
+	cmd.PersistentFlags().BoolP("tracing.enabled", "t", true, "enable opentracing")
+	cmd.PersistentFlags().String("tracing.service-name", "", "service name reported to the tracing backend")
+	cmd.PersistentFlags().StringToString("tracing.resource-attributes", nil, "extra attributes attached to every span, e.g. env=prod,zone=a")
+	cmd.PersistentFlags().String("tracing.exporter", "jaeger", "trace exporter: jaeger, otlp, stdout or file")
+	cmd.PersistentFlags().StringP("tracing.jaeger-collector-url", "r", telemetry.DefaultJaegerCollectorEndpoint, "jaeger collector URL")
+	cmd.PersistentFlags().String("tracing.otlp-endpoint", "", "OTLP collector endpoint (host:port for grpc, URL for http)")
+	cmd.PersistentFlags().String("tracing.otlp-protocol", "grpc", "OTLP protocol: grpc or http")
+	cmd.PersistentFlags().Bool("tracing.otlp-insecure", false, "connect to the OTLP collector without TLS")
+	cmd.PersistentFlags().String("tracing.otlp-ca-file", "", "CA verifying the OTLP collector over grpc, the system roots by default")
+	cmd.PersistentFlags().String("tracing.file-path", "", "file the spans are written to by the file exporter")
+	cmd.PersistentFlags().String("tracing.sampler", "always", "trace sampler: always, never or ratio")
+	cmd.PersistentFlags().Float64("tracing.sampler-ratio", 1.0, "ratio of traces sampled by the ratio sampler")
+	cmd.PersistentFlags().Bool("tracing.sampler-parent-based", false, "follow the sampling decision of the parent span")
+	cmd.PersistentFlags().Duration("tracing.metrics-period", telemetry.DefaultMetricsPeriod, "period of pushing the metrics to the otlp, stdout or file exporter")
+	cmd.PersistentFlags().String("tls.cert-file", "", "certificate presented to peers and clients, enables TLS")
+	cmd.PersistentFlags().String("tls.key-file", "", "private key of the certificate")
+	cmd.PersistentFlags().String("tls.ca-file", "", "CA verifying the certificates of the peers and, on servers, of the clients")
 }
-
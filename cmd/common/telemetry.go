@@ -5,26 +5,41 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// tracingFlags maps the keys of the tracing section in the config file to the flags overriding them
+var tracingFlags = map[string]string{
+	"tracing.enabled":                           "tracing.enabled",
+	"tracing.serviceName":                       "tracing.service-name",
+	"tracing.exporter.type":                     "tracing.exporter",
+	"tracing.exporter.jaeger.collectorEndpoint": "tracing.jaeger-collector-url",
+	"tracing.exporter.otlp.endpoint":            "tracing.otlp-endpoint",
+	"tracing.exporter.otlp.protocol":            "tracing.otlp-protocol",
+	"tracing.exporter.otlp.insecure":            "tracing.otlp-insecure",
+	"tracing.exporter.otlp.caFile":              "tracing.otlp-ca-file",
+	"tracing.exporter.file.path":                "tracing.file-path",
+	"tracing.sampler.type":                      "tracing.sampler",
+	"tracing.sampler.ratio":                     "tracing.sampler-ratio",
+	"tracing.sampler.parentBased":               "tracing.sampler-parent-based",
+	"tracing.metricsPeriod":                     "tracing.metrics-period",
+}
+
+// GetTelemetryConfig builds the telemetry config of the command being run
+// from the config file, the environment and the tracing.* flags
 func GetTelemetryConfig(cmd *cobra.Command) (telemetry.Config, error) {
-	tracingEnabled, err := cmd.PersistentFlags().GetBool("tracing.enabled")
+	v, err := NewViper(cmd, tracingFlags)
 	if err != nil {
 		return telemetry.Config{}, err
 	}
 
-	jaegerCollectorURL, err := cmd.PersistentFlags().GetString("tracing.jaeger-collector-url")
-	if err != nil {
+	var cfg struct {
+		Tracing telemetry.Config `mapstructure:"tracing"`
+	}
+	if err := v.Unmarshal(&cfg); err != nil {
 		return telemetry.Config{}, err
 	}
 
-	cfg := telemetry.Config{
-		Enabled:  tracingEnabled,
-		Exporter: telemetry.ExporterConfig{
-			Type:   "jaeger",
-			Jaeger: telemetry.JaegerExporterConfig{
-				CollectorEndpoint: jaegerCollectorURL,
-			},
-		},
+	cfg.Tracing.ResourceAttributes, err = mergeKeyValues(cmd, "tracing.resource-attributes", cfg.Tracing.ResourceAttributes)
+	if err != nil {
+		return telemetry.Config{}, err
 	}
-
-	return cfg, nil
+	return cfg.Tracing, nil
 }
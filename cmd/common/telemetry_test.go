@@ -0,0 +1,76 @@
+package common
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestCommand(t *testing.T, args ...string) *cobra.Command {
+	var flags CommonFlags
+	cmd := &cobra.Command{Use: "test"}
+	AddCommonPflags(cmd, &flags)
+	assert.Nil(t, cmd.ParseFlags(args))
+	return cmd
+}
+
+func TestGetTelemetryConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chordio-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "chordio.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(`
+tracing:
+  serviceName: from-file
+  resourceAttributes:
+    env: prod
+  exporter:
+    type: otlp
+    otlp:
+      endpoint: collector:55680
+  sampler:
+    type: ratio
+    ratio: 0.25
+`), 0644))
+
+	t.Run("flag defaults", func(t *testing.T) {
+		cfg, err := GetTelemetryConfig(newTestCommand(t))
+		assert.Nil(t, err)
+		assert.True(t, cfg.Enabled)
+		assert.Equal(t, "jaeger", cfg.Exporter.Type)
+		assert.Equal(t, "always", cfg.Sampler.Type)
+		assert.Equal(t, 1.0, cfg.Sampler.Ratio)
+	})
+
+	t.Run("config file overrides flag defaults", func(t *testing.T) {
+		cfg, err := GetTelemetryConfig(newTestCommand(t, "--config", configFile))
+		assert.Nil(t, err)
+		assert.Equal(t, "from-file", cfg.ServiceName)
+		assert.Equal(t, "otlp", cfg.Exporter.Type)
+		assert.Equal(t, "collector:55680", cfg.Exporter.OTLP.Endpoint)
+		assert.Equal(t, "grpc", cfg.Exporter.OTLP.Protocol)
+		assert.Equal(t, "ratio", cfg.Sampler.Type)
+		assert.Equal(t, 0.25, cfg.Sampler.Ratio)
+		assert.Equal(t, map[string]string{"env": "prod"}, cfg.ResourceAttributes)
+	})
+
+	t.Run("environment overrides config file, flags override environment", func(t *testing.T) {
+		os.Setenv("CHORDIO_TRACING_SERVICE_NAME", "from-env")
+		os.Setenv("CHORDIO_TRACING_SAMPLER_RATIO", "0.5")
+		os.Setenv("CHORDIO_TRACING_RESOURCE_ATTRIBUTES", "env=staging,zone=a")
+		defer os.Unsetenv("CHORDIO_TRACING_SERVICE_NAME")
+		defer os.Unsetenv("CHORDIO_TRACING_SAMPLER_RATIO")
+		defer os.Unsetenv("CHORDIO_TRACING_RESOURCE_ATTRIBUTES")
+
+		cfg, err := GetTelemetryConfig(newTestCommand(t, "--config", configFile, "--tracing.sampler-ratio", "0.75", "--tracing.resource-attributes", "zone=b", "--tracing.otlp-ca-file", "/etc/ssl/collector.pem"))
+		assert.Nil(t, err)
+		assert.Equal(t, "/etc/ssl/collector.pem", cfg.Exporter.OTLP.CAFile)
+		assert.Equal(t, "from-env", cfg.ServiceName)
+		assert.Equal(t, 0.75, cfg.Sampler.Ratio)
+		assert.Equal(t, map[string]string{"env": "staging", "zone": "b"}, cfg.ResourceAttributes)
+	})
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"github.com/kevinjqiu/chordio"
+	"github.com/spf13/cobra"
+)
+
+// tlsFlags maps the keys of the tls section in the config file to the flags overriding them
+var tlsFlags = map[string]string{
+	"tls.certFile": "tls.cert-file",
+	"tls.keyFile":  "tls.key-file",
+	"tls.caFile":   "tls.ca-file",
+}
+
+// GetTLSConfig builds the TLS config of the command being run
+// from the config file, the environment and the tls.* flags
+func GetTLSConfig(cmd *cobra.Command) (chordio.TLSConfig, error) {
+	v, err := NewViper(cmd, tlsFlags)
+	if err != nil {
+		return chordio.TLSConfig{}, err
+	}
+
+	var cfg struct {
+		TLS chordio.TLSConfig `mapstructure:"tls"`
+	}
+	if err := v.Unmarshal(&cfg); err != nil {
+		return chordio.TLSConfig{}, err
+	}
+	return cfg.TLS, nil
+}
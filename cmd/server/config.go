@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"github.com/kevinjqiu/chordio"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/cmd/common"
+	"github.com/spf13/cobra"
+	"strconv"
+)
+
+// serverFlags maps the keys of the config file to the flags overriding them
+var serverFlags = map[string]string{
+	"id":                           "id",
+	"rank":                         "rank",
+	"bind":                         "bind",
+	"advertise":                    "advertise",
+	"seeds":                        "seeds",
+	"health.bind":                  "health.bind",
+	"health.drainPeriod":           "health.drain-period",
+	"stabilization.disabled":       "stabilization.disabled",
+	"stabilization.period":         "stabilization.period",
+	"stabilization.jitter":         "stabilization.jitter",
+	"stabilization.adaptive":       "stabilization.adaptive",
+	"storage.engine":               "storage.engine",
+	"storage.reapPeriod":           "storage.reap-period",
+	"storage.tombstoneGracePeriod": "storage.tombstone-grace-period",
+	"storage.maxValueSize":         "storage.max-value-size",
+	"storage.maxPendingUploads":    "storage.max-pending-uploads",
+	"storage.uploadDir":            "storage.upload-dir",
+	"replication.factor":           "replication.factor",
+	"replication.maxHints":         "replication.max-hints",
+	"antiEntropy.period":           "anti-entropy.period",
+	"rpc.timeout":                  "rpc.timeout",
+	"rpc.retry.maxAttempts":        "rpc.retry.max-attempts",
+	"rpc.retry.budget":             "rpc.retry.budget",
+	"proximity.candidates":         "proximity.candidates",
+	"fixFingers.policy":            "fix-fingers.policy",
+	"fixFingers.period":            "fix-fingers.period",
+	"fixFingers.workers":           "fix-fingers.workers",
+}
+
+// serverConfig is the layout of the config file, besides the tracing and tls sections
+type serverConfig struct {
+	// ID is a string so that we can tell an unset ID from 0
+	ID            string                      `mapstructure:"id"`
+	Rank          uint32                      `mapstructure:"rank"`
+	Bind          string                      `mapstructure:"bind"`
+	Advertise     string                      `mapstructure:"advertise"`
+	Seeds         []string                    `mapstructure:"seeds"`
+	Stabilization chordio.StabilizationConfig `mapstructure:"stabilization"`
+	Health        chordio.HealthConfig        `mapstructure:"health"`
+	Storage       chordio.StorageConfig       `mapstructure:"storage"`
+	Replication   chordio.ReplicationConfig   `mapstructure:"replication"`
+	AntiEntropy   chordio.AntiEntropyConfig   `mapstructure:"antiEntropy"`
+	RPC           chordio.RPCConfig           `mapstructure:"rpc"`
+	Proximity     chordio.ProximityConfig     `mapstructure:"proximity"`
+	FixFingers    chordio.FixFingersConfig    `mapstructure:"fixFingers"`
+}
+
+// getConfig builds the server config from the config file, the environment and the flags
+func getConfig(cmd *cobra.Command) (chordio.Config, error) {
+	v, err := common.NewViper(cmd, serverFlags)
+	if err != nil {
+		return chordio.Config{}, err
+	}
+
+	var sc serverConfig
+	if err := v.Unmarshal(&sc); err != nil {
+		return chordio.Config{}, err
+	}
+
+	tlsConfig, err := common.GetTLSConfig(cmd)
+	if err != nil {
+		return chordio.Config{}, err
+	}
+
+	if sc.Rank == 0 {
+		return chordio.Config{}, &chordio.ConfigError{Field: "rank", Reason: "must be set"}
+	}
+
+	config := chordio.Config{
+		M:             chord.Rank(sc.Rank),
+		Bind:          sc.Bind,
+		Advertise:     sc.Advertise,
+		Seeds:         sc.Seeds,
+		Stabilization: sc.Stabilization,
+		Health:        sc.Health,
+		TLS:           tlsConfig,
+		Storage:       sc.Storage,
+		Replication:   sc.Replication,
+		AntiEntropy:   sc.AntiEntropy,
+		RPC:           sc.RPC,
+		Proximity:     sc.Proximity,
+		FixFingers:    sc.FixFingers,
+	}
+
+	if sc.ID == "" {
+		// the ID is derived from the address the peers know the node by, which must be valid first
+		if err := config.Validate(); err != nil {
+			return chordio.Config{}, err
+		}
+		advertise, err := config.AdvertiseAddr()
+		if err != nil {
+			return chordio.Config{}, err
+		}
+		config.ID = node.AssignID([]byte(advertise), config.M)
+	} else {
+		uintID, err := strconv.ParseUint(sc.ID, 10, 64)
+		if err != nil {
+			return chordio.Config{}, &chordio.ConfigError{Field: "id", Reason: fmt.Sprintf("cannot parse %q as an integer", sc.ID)}
+		}
+		config.ID = chord.ID(uintID)
+	}
+
+	if err := config.Validate(); err != nil {
+		return chordio.Config{}, err
+	}
+	return config, nil
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"github.com/kevinjqiu/chordio"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/cmd/common"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCommand(t *testing.T, args ...string) *cobra.Command {
+	var flags common.CommonFlags
+	cmd := NewServerCommand()
+	common.AddCommonPflags(cmd, &flags)
+	assert.Nil(t, cmd.ParseFlags(args))
+	return cmd
+}
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	configFile := filepath.Join(dir, "chordio.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(content), 0644))
+	return configFile
+}
+
+func TestGetConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chordio-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile := writeConfigFile(t, dir, `
+id: 3
+rank: 5
+bind: 127.0.0.1:2000
+seeds:
+  - 127.0.0.1:3000
+  - 127.0.0.1:4000
+stabilization:
+  period: 30s
+  jitter: 1s
+tls:
+  certFile: node.crt
+  keyFile: node.key
+storage:
+  engine: memory
+  reapPeriod: 30s
+  maxValueSize: 1048576
+replication:
+  factor: 2
+  maxHints: 500
+antiEntropy:
+  period: 5m
+fixFingers:
+  policy: round-robin
+  period: 2s
+rpc:
+  timeouts:
+    FindSuccessor: 10s
+  retry:
+    maxAttempts: 5
+    initialBackoff: 50ms
+`)
+
+	t.Run("config file", func(t *testing.T) {
+		cfg, err := getConfig(newTestCommand(t, "--config", configFile))
+		assert.Nil(t, err)
+		assert.Equal(t, chord.ID(3), cfg.ID)
+		assert.Equal(t, chord.Rank(5), cfg.M)
+		assert.Equal(t, "127.0.0.1:2000", cfg.Bind)
+		assert.Equal(t, []string{"127.0.0.1:3000", "127.0.0.1:4000"}, cfg.Seeds)
+		assert.Equal(t, 30*time.Second, cfg.Stabilization.Period)
+		assert.Equal(t, time.Second, cfg.Stabilization.Jitter)
+		assert.Equal(t, "node.crt", cfg.TLS.CertFile)
+		assert.Equal(t, "memory", cfg.Storage.Engine)
+		assert.Equal(t, 30*time.Second, cfg.Storage.ReapPeriod)
+		assert.Equal(t, int64(1<<20), cfg.Storage.MaxValueSize)
+		assert.Equal(t, 2, cfg.Replication.Factor)
+		assert.Equal(t, 500, cfg.Replication.MaxHints)
+		assert.Equal(t, 5*time.Minute, cfg.AntiEntropy.Period)
+		assert.Equal(t, map[string]time.Duration{"findsuccessor": 10 * time.Second}, cfg.RPC.Timeouts)
+		assert.Equal(t, 5, cfg.RPC.Retry.MaxAttempts)
+		assert.Equal(t, 50*time.Millisecond, cfg.RPC.Retry.InitialBackoff)
+		assert.Equal(t, 5*time.Second, cfg.RPC.Timeout)
+		assert.Equal(t, 3, cfg.Proximity.Candidates)
+		assert.Equal(t, "round-robin", cfg.FixFingers.Policy)
+		assert.Equal(t, 2*time.Second, cfg.FixFingers.Period)
+	})
+
+	t.Run("environment overrides config file, flags override environment", func(t *testing.T) {
+		os.Setenv("CHORDIO_RANK", "6")
+		os.Setenv("CHORDIO_SEEDS", "127.0.0.1:5000,127.0.0.1:6000")
+		os.Setenv("CHORDIO_STABILIZATION_PERIOD", "1m")
+		defer os.Unsetenv("CHORDIO_RANK")
+		defer os.Unsetenv("CHORDIO_SEEDS")
+		defer os.Unsetenv("CHORDIO_STABILIZATION_PERIOD")
+
+		cfg, err := getConfig(newTestCommand(t, "--config", configFile, "--stabilization.period", "2m", "--anti-entropy.period", "30s"))
+		assert.Nil(t, err)
+		assert.Equal(t, chord.Rank(6), cfg.M)
+		assert.Equal(t, []string{"127.0.0.1:5000", "127.0.0.1:6000"}, cfg.Seeds)
+		assert.Equal(t, 2*time.Minute, cfg.Stabilization.Period)
+		assert.Equal(t, 30*time.Second, cfg.AntiEntropy.Period)
+	})
+
+	t.Run("advertise address", func(t *testing.T) {
+		testCases := []struct {
+			name      string
+			args      []string
+			advertise string
+		}{
+			{"IPv6 bind", []string{"--bind", "[::1]:2000"}, "[::1]:2000"},
+			{"hostname bind", []string{"--bind", "localhost:2000"}, "localhost:2000"},
+			{"unix domain socket bind", []string{"--bind", "unix:///var/run/chordio.sock"}, "unix:///var/run/chordio.sock"},
+			{"explicit advertise", []string{"--bind", "0.0.0.0:2000", "--advertise", "chordio-0.chordio:2000"}, "chordio-0.chordio:2000"},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				cfg, err := getConfig(newTestCommand(t, append(tc.args, "--rank", "5")...))
+				assert.Nil(t, err)
+				advertise, err := cfg.AdvertiseAddr()
+				assert.Nil(t, err)
+				assert.Equal(t, tc.advertise, advertise)
+				assert.Equal(t, node.AssignID([]byte(tc.advertise), 5), cfg.ID)
+			})
+		}
+	})
+
+	t.Run("validation errors name the offending field", func(t *testing.T) {
+		testCases := []struct {
+			config string
+			field  string
+		}{
+			{"bind: 127.0.0.1:2000", "rank"},
+			{"rank: 3\nid: abc\nbind: 127.0.0.1:2000", "id"},
+			{"rank: 3\nid: 8\nbind: 127.0.0.1:2000", "id"},
+			{"rank: 64\nbind: 127.0.0.1:2000", "rank"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nseeds: ['']", "seeds[0]"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstabilization:\n  jitter: -1s", "stabilization.jitter"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstabilization:\n  adaptive: true\n  period: 10s\n  maxPeriod: 5s", "stabilization.maxPeriod"},
+			{"rank: 3\nbind: 127.0.0.1:2000\ntls:\n  certFile: node.crt", "tls.keyFile"},
+			{"rank: 3\nbind: 127.0.0.1:2000\ntls:\n  caFile: ca.crt", "tls.caFile"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nhealth:\n  drainPeriod: -1s", "health.drainPeriod"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstorage:\n  engine: rocksdb", "storage.engine"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstorage:\n  reapPeriod: -1s", "storage.reapPeriod"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstorage:\n  tombstoneGracePeriod: -1s", "storage.tombstoneGracePeriod"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstorage:\n  maxValueSize: -1", "storage.maxValueSize"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nstorage:\n  maxPendingUploads: -1", "storage.maxPendingUploads"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nreplication:\n  factor: -1", "replication.factor"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nreplication:\n  maxHints: -1", "replication.maxHints"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nantiEntropy:\n  period: -1s", "antiEntropy.period"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nrpc:\n  timeout: -1s", "rpc.timeout"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nrpc:\n  retry:\n    backoffMultiplier: 0.5", "rpc.retry.backoffMultiplier"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nproximity:\n  candidates: -1", "proximity.candidates"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nfixFingers:\n  policy: sometimes", "fixFingers.policy"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nfixFingers:\n  workers: -1", "fixFingers.workers"},
+			{"rank: 3\nbind: 127.0.0.1", "bind"},
+			{"rank: 3\nbind: '::1:2000'", "bind"},
+			{"rank: 3\nbind: 127.0.0.1:0", "advertise"},
+			{"rank: 3\nbind: 127.0.0.1:2000\nadvertise: 0.0.0.0:2000", "advertise"},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.field, func(t *testing.T) {
+				configFile := writeConfigFile(t, dir, tc.config)
+				_, err := getConfig(newTestCommand(t, "--config", configFile))
+				if assert.IsType(t, &chordio.ConfigError{}, err) {
+					assert.Equal(t, tc.field, err.(*chordio.ConfigError).Field)
+				}
+			})
+		}
+	})
+}
@@ -1,121 +1,102 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/kevinjqiu/chordio"
-	"github.com/kevinjqiu/chordio/chord"
 	"github.com/kevinjqiu/chordio/chord/node"
 	"github.com/kevinjqiu/chordio/cmd/common"
 	"github.com/kevinjqiu/chordio/telemetry"
-	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"math"
+	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 )
 
-type stabilizationConfig struct {
-	disabled bool
-	period   time.Duration
-	jitter   time.Duration
-}
-
-type runFlags struct {
-	common.CommonFlags
-	id            string
-	m             uint32
-	bind          string
-	stabilization stabilizationConfig
-}
-
-func mustBind(bind string) string {
-	var err error
-
-	parts := strings.Split(bind, ":")
-	if len(parts) != 2 {
-		logrus.Fatal("Invalid bind format")
-	}
-
-	ip := parts[0]
-	if ip == "" {
-		ip, err = getFirstAvailableBindIP()
-		if err != nil {
-			logrus.Fatal(err)
-		}
-	} else {
-		if !canBindIP(ip) {
-			logrus.Fatalf("cannot bind to IP %s: %s", ip, err)
-		}
-	}
-
-	return fmt.Sprintf("%s:%s", ip, parts[1])
-}
-
 func NewServerCommand() *cobra.Command {
-	var flags runFlags
 	cmd := &cobra.Command{
-		Use: "server",
+		Use:          "server",
+		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if flags.m == 0 {
-				return errors.New("Chord ring rank (m) must be specified")
+			config, err := getConfig(cmd)
+			if err != nil {
+				return err
 			}
 
-			bind := mustBind(flags.bind)
-
-			var id chord.ID
-			if flags.id == "" {
-				id = node.AssignID([]byte(bind), chord.Rank(flags.m))
-			} else {
-				uintID, err := strconv.ParseUint(flags.id, 10, 64)
-				if err != nil {
-					return errors.Wrap(err, "cannot parse id")
-				}
-				if float64(id) >= math.Pow(2.0, float64(flags.m)) {
-					return errors.New("invalid id: id must between 0 and 2**m")
-				}
-				id = chord.ID(uintID)
+			tcon, err := common.GetTelemetryConfig(cmd)
+			if err != nil {
+				return err
 			}
-
-			tcon, err := common.GetTelemetryConfig(cmd.Parent())
+			if tcon.ServiceName == "" {
+				tcon.ServiceName = fmt.Sprintf("chordio/#%d", config.ID)
+			}
+			tcon.ResourceAttributes["node.id"] = strconv.FormatUint(config.ID.AsU64(), 10)
+			tcon.ResourceAttributes["node.rank"] = strconv.FormatUint(uint64(config.M), 10)
+			advertise, err := config.AdvertiseAddr()
 			if err != nil {
 				return err
 			}
+			tcon.ResourceAttributes["node.bind"] = advertise
 
-			flushFunc, err := telemetry.Init(fmt.Sprintf("chordio/#%d", id), tcon)
+			flushFunc, err := telemetry.Init(tcon)
 			if err != nil {
 				return err
 			}
 			defer flushFunc()
 
-			config := chordio.Config{
-				ID:   id,
-				M:    chord.Rank(flags.m),
-				Bind: flags.bind,
-				Stabilization: chordio.StabilizationConfig{
-					Disabled: flags.stabilization.disabled,
-					Period:   flags.stabilization.period,
-					Jitter:   flags.stabilization.jitter,
-				},
-			}
-
 			server, err := chordio.NewServer(config)
 			if err != nil {
 				return err
 			}
-			if err := server.Serve(); err != nil {
-				return err
-			}
-			return nil
+
+			// leave the ring on interrupt
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(signals)
+			go func() {
+				select {
+				case sig := <-signals:
+					logrus.Infof("received %s, stopping", sig)
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			return server.Run(ctx)
 		},
 	}
 
-	cmd.Flags().StringVarP(&flags.id, "id", "i", "", "assign an ID to the node")
-	cmd.Flags().Uint32VarP(&flags.m, "rank", "m", 0, "the rank of the ring")
-	cmd.Flags().StringVarP(&flags.bind, "bind", "b", "localhost:2000", "bind address")
-	cmd.Flags().BoolVarP(&flags.stabilization.disabled, "stabilization.disabled", "d", false, "disable stabilization for debugging")
-	cmd.Flags().DurationVarP(&flags.stabilization.period, "stabilization.period", "p", 10*time.Second, "set the stabilization run interval")
-	cmd.Flags().DurationVarP(&flags.stabilization.jitter, "stabilization.jitter", "j", 5*time.Second, "set the stabilization run jitter to avoid all nodes run stabilization at the same time")
+	cmd.Flags().StringP("id", "i", "", "assign an ID to the node")
+	cmd.Flags().Uint32P("rank", "m", 0, "the rank of the ring")
+	cmd.Flags().StringP("bind", "b", "localhost:2000", "listen address, of the form [host]:port with IPv6 hosts in brackets, or unix:///path/to/socket")
+	cmd.Flags().String("advertise", "", "address peers dial to reach the node, defaults to the listen address, or the first available IP when listening on all interfaces")
+	cmd.Flags().StringSlice("seeds", nil, "addresses of the nodes to join the ring through on start, tried in order until one of them succeeds")
+	cmd.Flags().String("health.bind", "", "serve the HTTP health endpoints (/healthz, /readyz) at this address")
+	cmd.Flags().Duration("health.drain-period", 5*time.Second, "how long a stopping node reports it's not serving before it leaves the ring")
+	cmd.Flags().BoolP("stabilization.disabled", "d", false, "disable stabilization for debugging")
+	cmd.Flags().DurationP("stabilization.period", "p", 10*time.Second, "set the stabilization run interval")
+	cmd.Flags().DurationP("stabilization.jitter", "j", 5*time.Second, "set the upper bound of the random delay added to every stabilization run to avoid all nodes run stabilization at the same time")
+	cmd.Flags().Bool("stabilization.adaptive", false, "run stabilization more often while the finger table changes and less often once it's stable")
+	cmd.Flags().String("storage.engine", chordio.StorageEngineMemory, "storage engine of the keys owned by the node")
+	cmd.Flags().Duration("storage.reap-period", time.Minute, "period of removing the expired keys, never if 0")
+	cmd.Flags().Duration("storage.tombstone-grace-period", 24*time.Hour, "how long the tombstones of the deleted keys are kept, replicas that missed a delete for longer may bring the key back")
+	cmd.Flags().Int64("storage.max-value-size", 1<<30, "maximum size in bytes of a value uploaded in chunks")
+	cmd.Flags().Int("storage.max-pending-uploads", 16, "maximum number of uploads in progress or interrupted")
+	cmd.Flags().String("storage.upload-dir", "", "directory the uploads are spooled to until they're complete, the temporary directory if empty")
+	cmd.Flags().Int("replication.factor", 3, "number of nodes storing every key, its owner and the next successors")
+	cmd.Flags().Int("replication.max-hints", 10000, "maximum number of writes kept for the unreachable replicas of other nodes")
+	cmd.Flags().Duration("anti-entropy.period", time.Minute, "period of comparing the owned keys with their replicas and repairing the differences, never if 0")
+	cmd.Flags().Duration("rpc.timeout", node.DefaultRPCPolicy.Timeout, "timeout of every attempt of a call to a peer")
+	cmd.Flags().Int("rpc.retry.max-attempts", node.DefaultRPCPolicy.MaxAttempts, "attempts of the idempotent calls to a peer, including the first one")
+	cmd.Flags().Float64("rpc.retry.budget", node.DefaultRPCPolicy.RetryBudget, "ratio of retries to calls to the peers")
+	cmd.Flags().Int("proximity.candidates", 3, "number of nodes considered for every finger, the one with the lowest round-trip time is picked")
+	cmd.Flags().String("fix-fingers.policy", string(node.FixFingersAll), "finger table entries re-resolved every time: all, round-robin, random or adaptive")
+	cmd.Flags().Duration("fix-fingers.period", 0, "period of fixing the fingers, they're fixed on every stabilization if 0")
+	cmd.Flags().Int("fix-fingers.workers", 4, "maximum number of concurrent lookups when fixing the fingers")
 	return cmd
 }
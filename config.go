@@ -1,20 +1,350 @@
 package chordio
 
 import (
+	"fmt"
 	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/kevinjqiu/chordio/transport"
 	"time"
 )
 
+// maxRank keeps the IDs of the ring within uint64
+const maxRank = 63
+
+// StorageEngineMemory keeps the keys in memory, they're lost when the node stops
+const StorageEngineMemory = "memory"
+
+// defaultMaxHints bounds the writes kept for the unreachable replicas of other nodes by default
+const defaultMaxHints = 10000
+
+// defaultTombstoneGracePeriod is how long the tombstones of the deleted keys are kept by default
+const defaultTombstoneGracePeriod = 24 * time.Hour
+
+// defaultMaxValueSize bounds the size of the values uploaded in chunks by default
+const defaultMaxValueSize = 1 << 30
+
+// defaultMaxPendingUploads bounds the number of uploads in progress or interrupted by default
+const defaultMaxPendingUploads = 16
+
 type StabilizationConfig struct {
-	Disabled bool
-	Period   time.Duration
-	Jitter   time.Duration
+	Disabled bool          `mapstructure:"disabled"`
+	Period   time.Duration `mapstructure:"period"`
+	// Jitter is the upper bound of the random delay added to every round, none if 0
+	Jitter time.Duration `mapstructure:"jitter"`
+	// Adaptive halves the period after a round that changed the finger table, down to MinPeriod,
+	// and doubles it after a round that didn't, up to MaxPeriod
+	Adaptive bool `mapstructure:"adaptive"`
+	// MinPeriod and MaxPeriod bound the adaptive period, Period/4 and Period*4 if 0
+	MinPeriod time.Duration `mapstructure:"minPeriod"`
+	MaxPeriod time.Duration `mapstructure:"maxPeriod"`
+}
+
+func (c StabilizationConfig) minPeriod() time.Duration {
+	if c.MinPeriod == 0 {
+		return c.Period / 4
+	}
+	return c.MinPeriod
+}
+
+func (c StabilizationConfig) maxPeriod() time.Duration {
+	if c.MaxPeriod == 0 {
+		return c.Period * 4
+	}
+	return c.MaxPeriod
+}
+
+type HealthConfig struct {
+	// The address to serve the HTTP health endpoints on, disabled if empty
+	Bind string `mapstructure:"bind"`
+	// DrainPeriod is how long a stopping node reports it's not serving before it leaves the ring,
+	// for the load balancers polling it to stop sending it requests. It leaves right away if 0
+	DrainPeriod time.Duration `mapstructure:"drainPeriod"`
+}
+
+type TLSConfig struct {
+	// CertFile and KeyFile are the certificate presented to clients and peers, TLS is disabled if empty
+	CertFile string `mapstructure:"certFile"`
+	KeyFile  string `mapstructure:"keyFile"`
+	// CAFile verifies the certificates of the peers.
+	// When set, servers also require clients to present a certificate signed by it
+	CAFile string `mapstructure:"caFile"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+type StorageConfig struct {
+	// Engine storing the keys owned by the node, defaults to "memory"
+	Engine string `mapstructure:"engine"`
+	// ReapPeriod is the period of removing the expired keys, which are hidden as soon as they expire.
+	// They're never removed if 0
+	ReapPeriod time.Duration `mapstructure:"reapPeriod"`
+	// TombstoneGracePeriod is how long the tombstone of a deleted key is kept before it's reaped,
+	// 24h if 0. A replica that missed the delete for longer may bring the value back
+	TombstoneGracePeriod time.Duration `mapstructure:"tombstoneGracePeriod"`
+	// MaxValueSize bounds the size in bytes of a value uploaded in chunks, 1GiB if 0
+	MaxValueSize int64 `mapstructure:"maxValueSize"`
+	// MaxPendingUploads bounds the number of uploads in progress or interrupted and not expired yet, 16 if 0
+	MaxPendingUploads int `mapstructure:"maxPendingUploads"`
+	// UploadDir is where the uploads are spooled until they're complete, the temporary directory if empty
+	UploadDir string `mapstructure:"uploadDir"`
+}
+
+func (c StorageConfig) tombstoneGracePeriod() time.Duration {
+	if c.TombstoneGracePeriod == 0 {
+		return defaultTombstoneGracePeriod
+	}
+	return c.TombstoneGracePeriod
+}
+
+func (c StorageConfig) maxValueSize() int64 {
+	if c.MaxValueSize == 0 {
+		return defaultMaxValueSize
+	}
+	return c.MaxValueSize
+}
+
+func (c StorageConfig) maxPendingUploads() int {
+	if c.MaxPendingUploads == 0 {
+		return defaultMaxPendingUploads
+	}
+	return c.MaxPendingUploads
+}
+
+// newEngine returns an empty engine of the configured type
+func (c StorageConfig) newEngine() (storage.Engine, error) {
+	switch c.Engine {
+	case "", StorageEngineMemory:
+		return storage.NewMemory(), nil
+	default:
+		return nil, &ConfigError{Field: "storage.engine", Reason: fmt.Sprintf("unknown engine %q, must be %q", c.Engine, StorageEngineMemory)}
+	}
+}
+
+type FixFingersConfig struct {
+	// Policy picks the finger table entries re-resolved every time: "all" (the default),
+	// "round-robin", "random" or "adaptive" to the churn
+	Policy string `mapstructure:"policy"`
+	// Period of fixing the fingers, they're fixed on every stabilization if 0
+	Period time.Duration `mapstructure:"period"`
+	// Workers bounds the number of concurrent lookups when fixing the fingers, 4 if 0
+	Workers int `mapstructure:"workers"`
+}
+
+type ProximityConfig struct {
+	// Candidates is the number of nodes considered for every finger, the one with the lowest
+	// round-trip time is picked. Fingers are picked by ID only if it's 0 or 1
+	Candidates int `mapstructure:"candidates"`
+}
+
+type ReplicationConfig struct {
+	// Factor is the number of nodes storing every key: its owner and the next Factor-1 successors.
+	// Keys are only stored on their owner if it's 0 or 1
+	Factor int `mapstructure:"factor"`
+	// MaxHints bounds the writes kept by the node for the unreachable replicas of other nodes, 10000 if 0
+	MaxHints int `mapstructure:"maxHints"`
+}
+
+func (c ReplicationConfig) maxHints() int {
+	if c.MaxHints == 0 {
+		return defaultMaxHints
+	}
+	return c.MaxHints
+}
+
+func (c ReplicationConfig) factor() int {
+	if c.Factor < 1 {
+		return 1
+	}
+	return c.Factor
+}
+
+type AntiEntropyConfig struct {
+	// Period of comparing the owned keys with their replicas and repairing the differences, never if 0
+	Period time.Duration `mapstructure:"period"`
+}
+
+type RetryConfig struct {
+	// MaxAttempts of the idempotent RPCs (GetNodeInfo, FindSuccessor, ClosestPrecedingFinger), including the first one
+	MaxAttempts int `mapstructure:"maxAttempts"`
+	// InitialBackoff is the wait before the first retry, multiplied by BackoffMultiplier
+	// for every further retry up to MaxBackoff
+	InitialBackoff    time.Duration `mapstructure:"initialBackoff"`
+	MaxBackoff        time.Duration `mapstructure:"maxBackoff"`
+	BackoffMultiplier float64       `mapstructure:"backoffMultiplier"`
+	// Budget is the ratio of retries to calls, e.g. 0.1 allows one retry for every ten calls
+	Budget float64 `mapstructure:"budget"`
+}
+
+// RPCConfig bounds the calls to the peers, zero values fall back to the defaults
+type RPCConfig struct {
+	// Timeout of every attempt of a call
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Timeouts overrides Timeout for the RPCs by name, e.g. FindSuccessor, regardless of the case
+	Timeouts map[string]time.Duration `mapstructure:"timeouts"`
+	Retry    RetryConfig              `mapstructure:"retry"`
+}
+
+func (c RPCConfig) policy() node.RPCPolicy {
+	return node.RPCPolicy{
+		Timeout:           c.Timeout,
+		Timeouts:          c.Timeouts,
+		MaxAttempts:       c.Retry.MaxAttempts,
+		InitialBackoff:    c.Retry.InitialBackoff,
+		MaxBackoff:        c.Retry.MaxBackoff,
+		BackoffMultiplier: c.Retry.BackoffMultiplier,
+		RetryBudget:       c.Retry.Budget,
+	}
 }
 
 type Config struct {
-	ID   chord.ID
-	M    chord.Rank
+	ID chord.ID
+	M  chord.Rank
+	// Bind is the address the server listens on
 	Bind string
+	// Advertise is the address peers dial to reach the node, when it differs from Bind,
+	// e.g. behind NAT or in a container. Derived from Bind if empty
+	Advertise string
+	// Seeds are the addresses of the nodes to join the ring through when the server starts,
+	// tried in order until one of them succeeds, again with a backoff until the server stops
+	Seeds []string
 	// Disable the stabilization protocol for debugging purposes
 	Stabilization StabilizationConfig
+	Health        HealthConfig
+	TLS           TLSConfig
+	Storage       StorageConfig
+	Replication   ReplicationConfig
+	AntiEntropy   AntiEntropyConfig
+	RPC           RPCConfig
+	Proximity     ProximityConfig
+	FixFingers    FixFingersConfig
+}
+
+// AdvertiseAddr is the address peers dial to reach the node
+func (c Config) AdvertiseAddr() (string, error) {
+	if c.Advertise != "" {
+		return c.Advertise, nil
+	}
+	return advertiseAddr(c.Bind)
+}
+
+// Validate the config, the returned error is a *ConfigError naming the offending field
+func (c Config) Validate() error {
+	if c.M == 0 || c.M > maxRank {
+		return &ConfigError{Field: "rank", Reason: fmt.Sprintf("must be between 1 and %d, got %d", maxRank, c.M)}
+	}
+	if max := chord.ID(2).Pow(c.M.AsInt()); c.ID >= max {
+		return &ConfigError{Field: "id", Reason: fmt.Sprintf("must be less than 2**rank (%d), got %d", max, c.ID)}
+	}
+	if _, _, err := splitBind(c.Bind); err != nil && !transport.IsLocal(c.Bind) {
+		return &ConfigError{Field: "bind", Reason: err.Error()}
+	}
+	if c.Advertise != "" && !transport.IsLocal(c.Advertise) {
+		host, port, err := splitBind(c.Advertise)
+		if err != nil {
+			return &ConfigError{Field: "advertise", Reason: err.Error()}
+		}
+		if isUnspecifiedHost(host) || port == 0 {
+			return &ConfigError{Field: "advertise", Reason: "must have a host and a port that peers can dial"}
+		}
+	} else if _, err := c.AdvertiseAddr(); err != nil {
+		return &ConfigError{Field: "advertise", Reason: "must be set: " + err.Error()}
+	}
+	for i, seed := range c.Seeds {
+		if seed == "" {
+			return &ConfigError{Field: fmt.Sprintf("seeds[%d]", i), Reason: "must not be empty"}
+		}
+	}
+	if !c.Stabilization.Disabled {
+		if c.Stabilization.Period <= 0 {
+			return &ConfigError{Field: "stabilization.period", Reason: fmt.Sprintf("must be positive, got %s", c.Stabilization.Period)}
+		}
+		if c.Stabilization.Jitter < 0 {
+			return &ConfigError{Field: "stabilization.jitter", Reason: fmt.Sprintf("must not be negative, got %s", c.Stabilization.Jitter)}
+		}
+		if c.Stabilization.Adaptive {
+			if min := c.Stabilization.minPeriod(); min <= 0 || min > c.Stabilization.Period {
+				return &ConfigError{Field: "stabilization.minPeriod", Reason: fmt.Sprintf("must be positive and at most the period, got %s", min)}
+			}
+			if max := c.Stabilization.maxPeriod(); max < c.Stabilization.Period {
+				return &ConfigError{Field: "stabilization.maxPeriod", Reason: fmt.Sprintf("must be at least the period, got %s", max)}
+			}
+		}
+	}
+	if c.TLS.CertFile == "" && c.TLS.KeyFile != "" {
+		return &ConfigError{Field: "tls.certFile", Reason: "must be set together with tls.keyFile"}
+	}
+	if c.TLS.CertFile != "" && c.TLS.KeyFile == "" {
+		return &ConfigError{Field: "tls.keyFile", Reason: "must be set together with tls.certFile"}
+	}
+	if c.TLS.CAFile != "" && !c.TLS.Enabled() {
+		return &ConfigError{Field: "tls.caFile", Reason: "requires tls.certFile and tls.keyFile"}
+	}
+	if c.Health.DrainPeriod < 0 {
+		return &ConfigError{Field: "health.drainPeriod", Reason: fmt.Sprintf("must not be negative, got %s", c.Health.DrainPeriod)}
+	}
+	if c.RPC.Timeout < 0 {
+		return &ConfigError{Field: "rpc.timeout", Reason: fmt.Sprintf("must not be negative, got %s", c.RPC.Timeout)}
+	}
+	for method, timeout := range c.RPC.Timeouts {
+		if timeout <= 0 {
+			return &ConfigError{Field: "rpc.timeouts." + method, Reason: fmt.Sprintf("must be positive, got %s", timeout)}
+		}
+	}
+	if c.RPC.Retry.MaxAttempts < 0 {
+		return &ConfigError{Field: "rpc.retry.maxAttempts", Reason: fmt.Sprintf("must not be negative, got %d", c.RPC.Retry.MaxAttempts)}
+	}
+	if c.RPC.Retry.InitialBackoff < 0 {
+		return &ConfigError{Field: "rpc.retry.initialBackoff", Reason: fmt.Sprintf("must not be negative, got %s", c.RPC.Retry.InitialBackoff)}
+	}
+	if c.RPC.Retry.MaxBackoff < 0 {
+		return &ConfigError{Field: "rpc.retry.maxBackoff", Reason: fmt.Sprintf("must not be negative, got %s", c.RPC.Retry.MaxBackoff)}
+	}
+	if m := c.RPC.Retry.BackoffMultiplier; m != 0 && m < 1 {
+		return &ConfigError{Field: "rpc.retry.backoffMultiplier", Reason: fmt.Sprintf("must be at least 1, got %g", m)}
+	}
+	if c.RPC.Retry.Budget < 0 {
+		return &ConfigError{Field: "rpc.retry.budget", Reason: fmt.Sprintf("must not be negative, got %g", c.RPC.Retry.Budget)}
+	}
+	if _, err := node.ParseFixFingersPolicy(c.FixFingers.Policy); err != nil {
+		return &ConfigError{Field: "fixFingers.policy", Reason: err.Error()}
+	}
+	if c.FixFingers.Period < 0 {
+		return &ConfigError{Field: "fixFingers.period", Reason: fmt.Sprintf("must not be negative, got %s", c.FixFingers.Period)}
+	}
+	if c.FixFingers.Workers < 0 {
+		return &ConfigError{Field: "fixFingers.workers", Reason: fmt.Sprintf("must not be negative, got %d", c.FixFingers.Workers)}
+	}
+	if c.Proximity.Candidates < 0 {
+		return &ConfigError{Field: "proximity.candidates", Reason: fmt.Sprintf("must not be negative, got %d", c.Proximity.Candidates)}
+	}
+	if c.Replication.Factor < 0 {
+		return &ConfigError{Field: "replication.factor", Reason: fmt.Sprintf("must not be negative, got %d", c.Replication.Factor)}
+	}
+	if c.Replication.MaxHints < 0 {
+		return &ConfigError{Field: "replication.maxHints", Reason: fmt.Sprintf("must not be negative, got %d", c.Replication.MaxHints)}
+	}
+	if c.AntiEntropy.Period < 0 {
+		return &ConfigError{Field: "antiEntropy.period", Reason: fmt.Sprintf("must not be negative, got %s", c.AntiEntropy.Period)}
+	}
+	if c.Storage.ReapPeriod < 0 {
+		return &ConfigError{Field: "storage.reapPeriod", Reason: fmt.Sprintf("must not be negative, got %s", c.Storage.ReapPeriod)}
+	}
+	if c.Storage.TombstoneGracePeriod < 0 {
+		return &ConfigError{Field: "storage.tombstoneGracePeriod", Reason: fmt.Sprintf("must not be negative, got %s", c.Storage.TombstoneGracePeriod)}
+	}
+	if c.Storage.MaxValueSize < 0 {
+		return &ConfigError{Field: "storage.maxValueSize", Reason: fmt.Sprintf("must not be negative, got %d", c.Storage.MaxValueSize)}
+	}
+	if c.Storage.MaxPendingUploads < 0 {
+		return &ConfigError{Field: "storage.maxPendingUploads", Reason: fmt.Sprintf("must not be negative, got %d", c.Storage.MaxPendingUploads)}
+	}
+	switch c.Storage.Engine {
+	case "", StorageEngineMemory:
+	default:
+		return &ConfigError{Field: "storage.engine", Reason: fmt.Sprintf("unknown engine %q, must be %q", c.Storage.Engine, StorageEngineMemory)}
+	}
+	return nil
 }
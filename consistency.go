@@ -0,0 +1,263 @@
+package chordio
+
+import (
+	"context"
+	"fmt"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// ParseConsistency parses the name of a consistency level regardless of the case, the empty string is ONE
+func ParseConsistency(s string) (pb.Consistency, error) {
+	if s == "" {
+		return pb.Consistency_ONE, nil
+	}
+	level, ok := pb.Consistency_value[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown consistency level %q", s)
+	}
+	return pb.Consistency(level), nil
+}
+
+// requiredAcks is the number of the n replicas of a key the consistency level waits for,
+// n is the replication factor rather than the replicas known, which may be fewer
+func requiredAcks(level pb.Consistency, n int) int {
+	switch level {
+	case pb.Consistency_ALL:
+		return n
+	case pb.Consistency_QUORUM:
+		return n/2 + 1
+	default:
+		return 1
+	}
+}
+
+func errNotEnoughReplicas(level pb.Consistency, acks, required int) error {
+	return status.Errorf(codes.Unavailable, "%s requires %d replicas, only %d responded", level, required, acks)
+}
+
+func errVersionMismatch(key string, current, expected uint64) error {
+	return status.Errorf(codes.FailedPrecondition, "the version of %q is %d, expected %d", key, current, expected)
+}
+
+// write stores the item on the local node, the owner, at a new version and copies it to the replicas.
+// Returns the item written once as many replicas as the consistency level requires acknowledged it,
+// counting the owner. The copies to the other replicas carry on in the background. The copy for a replica
+// that can't be reached is handed off to the next successor to be replayed once it's back, the hint doesn't
+// count as an acknowledgement: a read of as many replicas may not see it until then.
+// If expectedVersion isn't nil, the item is only written if the key is at that version, 0 if absent,
+// deleted or expired. The version is checked on the owner, after reading the newest copy from the replicas
+// unless the consistency level is ONE
+func (r *replicator) write(ctx context.Context, item storage.Item, level pb.Consistency, expectedVersion *uint64) (storage.Item, error) {
+	if expectedVersion != nil && level != pb.Consistency_ONE {
+		// the owner may have missed a write seen by the replicas
+		newest, _, err := r.read(ctx, item.Key, level)
+		if err != nil {
+			return storage.Item{}, err
+		}
+		if newest.found {
+			if err := r.merge(newest.item); err != nil {
+				return storage.Item{}, err
+			}
+		}
+	}
+
+	var mismatch error
+	now := time.Now()
+	err := r.store.Update(item.Key, func(current storage.Item, found bool) (storage.Item, bool) {
+		if expectedVersion != nil {
+			var version uint64
+			if found && current.Live(now) {
+				version = current.Version
+			}
+			if version != *expectedVersion {
+				mismatch = errVersionMismatch(item.Key, version, *expectedVersion)
+				return current, false
+			}
+		}
+		// a tombstone or an expired item still orders the writes, its copies may not have been reaped everywhere
+		item.Version = r.clock.next(current.Version)
+		return item, true
+	})
+	if err != nil {
+		return item, err
+	}
+	if mismatch != nil {
+		return item, mismatch
+	}
+
+	replicas := r.currentReplicas()
+	required := requiredAcks(level, r.replicationFactor())
+	acks := make(chan error, len(replicas))
+	for _, replica := range replicas {
+		go func(replica chord.NodeRef) {
+			// the copy outlives the request once enough replicas acknowledged it
+			ctx := context.Background()
+			err := r.pushItems(ctx, replica, []storage.Item{item})
+			var hinted bool
+			if node.IsTransient(err) {
+				// the replica is suspected down, a hint brings it up to date once it's back
+				if hintErr := r.handOff(ctx, replica, []storage.Item{item}); hintErr == nil {
+					hinted = true
+				} else {
+					logrus.Warnf("unable to hand %q off: %s", item.Key, hintErr)
+				}
+			}
+			if err != nil {
+				logrus.Warnf("unable to replicate to %s: %s", replica, err)
+				if !hinted {
+					r.markDirty()
+				}
+			}
+			acks <- err
+		}(replica)
+	}
+
+	acked, failed := 1, 0
+	for acked < required {
+		// fewer replicas may be known than the level requires
+		if len(replicas)+1-failed < required {
+			return item, errNotEnoughReplicas(level, acked, required)
+		}
+		select {
+		case err := <-acks:
+			if err != nil {
+				failed++
+			} else {
+				acked++
+			}
+		case <-ctx.Done():
+			return item, errors.Wrap(ctx.Err(), "unable to replicate")
+		}
+	}
+	return item, nil
+}
+
+// replicaRead is the copy of a key read from a replica, the local node if replica is nil
+type replicaRead struct {
+	replica chord.NodeRef
+	item    storage.Item
+	found   bool
+	err     error
+}
+
+// readLocal reads the copy of the key stored on the local node
+func (r *replicator) readLocal(key string) replicaRead {
+	item, found, err := r.store.Get(key)
+	return replicaRead{item: item, found: found, err: err}
+}
+
+// readReplica reads the copy of the key stored on the replica, in chunks if it's too large for a single message
+func (r *replicator) readReplica(ctx context.Context, replica chord.NodeRef, key string) replicaRead {
+	var resp *pb.GetReplicaResponse
+	err := r.dialer.Call(ctx, replica.GetBind(), "GetReplica", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.GetReplica(ctx, &pb.GetReplicaRequest{Key: key})
+		return err
+	})
+	if err != nil {
+		return replicaRead{err: err}
+	}
+	return r.replicaReadOf(ctx, replica, key, resp)
+}
+
+// readReplicas reads the copies of the keys stored on the replica in a single call,
+// then in chunks the ones too large for it. The reads are in the order of the keys
+func (r *replicator) readReplicas(ctx context.Context, replica chord.NodeRef, keys []string) ([]replicaRead, error) {
+	var resp *pb.GetReplicasResponse
+	err := r.dialer.Call(ctx, replica.GetBind(), "GetReplicas", func(ctx context.Context, client pb.ChordClient) (err error) {
+		resp, err = client.GetReplicas(ctx, &pb.GetReplicasRequest{Keys: keys})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Replicas) != len(keys) {
+		return nil, errors.Errorf("%s returned %d copies for %d keys", replica, len(resp.Replicas), len(keys))
+	}
+	reads := make([]replicaRead, len(keys))
+	for i, key := range keys {
+		reads[i] = r.replicaReadOf(ctx, replica, key, resp.Replicas[i])
+	}
+	return reads, nil
+}
+
+// replicaReadOf is the copy of the key in the response of the replica, read in chunks if it was left out
+func (r *replicator) replicaReadOf(ctx context.Context, replica chord.NodeRef, key string, resp *pb.GetReplicaResponse) replicaRead {
+	if !resp.Found {
+		return replicaRead{}
+	}
+	if resp.Chunked {
+		return r.readReplicaStream(ctx, replica, key)
+	}
+	return replicaRead{item: itemFromProto(resp.Item), found: true}
+}
+
+// read reads the key from the local node, its owner, and the replicas until as many of them responded
+// as the consistency level requires. Returns the newest copy, expired or not, divergent if the copies read disagree.
+// The newest copy is written back to the replicas read that are behind, in the background
+func (r *replicator) read(ctx context.Context, key string, level pb.Consistency) (newest replicaRead, divergent bool, err error) {
+	replicas := r.currentReplicas()
+	required := requiredAcks(level, r.replicationFactor())
+
+	reads := make(chan replicaRead, len(replicas)+1)
+	reads <- r.readLocal(key)
+	if required > 1 {
+		readCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		for _, replica := range replicas {
+			go func(replica chord.NodeRef) {
+				read := r.readReplica(readCtx, replica, key)
+				read.replica = replica
+				reads <- read
+			}(replica)
+		}
+	} else {
+		replicas = nil
+	}
+
+	var responses []replicaRead
+	for failed := 0; len(responses) < required; {
+		select {
+		case read := <-reads:
+			if read.err != nil {
+				logrus.Warnf("unable to read %q from a replica: %s", key, read.err)
+				failed++
+			} else {
+				r.clock.observe(read.item.Version)
+				responses = append(responses, read)
+			}
+		case <-ctx.Done():
+			return newest, false, errors.Wrap(ctx.Err(), "unable to read the replicas")
+		}
+		if len(replicas)+1-failed < required {
+			return newest, false, errNotEnoughReplicas(level, len(responses), required)
+		}
+	}
+
+	newest = responses[0]
+	for _, read := range responses[1:] {
+		if read.found && (!newest.found || read.item.NewerThan(newest.item)) {
+			newest = read
+		}
+	}
+	var stale []chord.NodeRef
+	for _, read := range responses {
+		if read.found != newest.found || newest.item.NewerThan(read.item) {
+			divergent = true
+			stale = append(stale, read.replica)
+		}
+	}
+	if divergent {
+		logrus.Warnf("the replicas of %q disagree, the newest version is %d", key, newest.item.Version)
+		r.repairReads(ctx, newest.item, stale)
+	}
+	return newest, divergent, nil
+}
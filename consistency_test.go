@@ -0,0 +1,137 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestConsistencyLevels(t *testing.T) {
+	t.Run("levels are parsed regardless of the case", func(t *testing.T) {
+		for s, level := range map[string]pb.Consistency{"": pb.Consistency_ONE, "quorum": pb.Consistency_QUORUM, "ALL": pb.Consistency_ALL} {
+			parsed, err := ParseConsistency(s)
+			assert.Nil(t, err)
+			assert.Equal(t, level, parsed)
+		}
+		_, err := ParseConsistency("most")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("the acknowledgements required are counted over the replicas", func(t *testing.T) {
+		assert.Equal(t, 1, requiredAcks(pb.Consistency_ONE, 3))
+		assert.Equal(t, 2, requiredAcks(pb.Consistency_QUORUM, 3))
+		assert.Equal(t, 3, requiredAcks(pb.Consistency_QUORUM, 4))
+		assert.Equal(t, 3, requiredAcks(pb.Consistency_ALL, 3))
+		assert.Equal(t, 1, requiredAcks(pb.Consistency_ALL, 1))
+	})
+}
+
+func TestConsistency(t *testing.T) {
+	nodes := map[uint64]testNode{
+		0: newReplicatedNode(0, 3, 3),
+		3: newReplicatedNode(3, 3, 3),
+		5: newReplicatedNode(5, 3, 3),
+	}
+	nodes[3].join(nodes[0])
+	nodes[5].join(nodes[0])
+	stabilizeRounds(4, nodes[0], nodes[3], nodes[5])
+
+	const key = "metadata"
+	assert.Nil(t, nodes[0].put(key, "v1", pb.Consistency_ALL))
+	resp, err := nodes[0].get(key, pb.Consistency_ONE)
+	assert.Nil(t, err)
+	owner := nodes[resp.Owner.Id]
+	// the requests are sent to the owner, whose lookups don't go through its successor
+	info := owner.status()
+	succ, pred := nodes[info.Node.Succ.Id], nodes[info.Node.Pred.Id]
+	defer owner.stop()
+	defer pred.stop()
+
+	t.Run("writes of consistency ALL are stored on every replica", func(t *testing.T) {
+		for _, n := range nodes {
+			assert.True(t, n.stores(key))
+		}
+	})
+
+	t.Run("reads return the newest version and report divergent replicas", func(t *testing.T) {
+		assert.Nil(t, owner.put(key, "v2", pb.Consistency_ALL))
+		newest, _, _ := owner.s.store.Get(key)
+		// the owner missed the last write
+		assert.Nil(t, owner.s.store.Put(storage.Item{Key: key, Value: []byte("v1"), Version: newest.Version - 1}))
+
+		resp, err := owner.get(key, pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", string(resp.Value))
+		assert.False(t, resp.Divergent)
+
+		resp, err = owner.get(key, pb.Consistency_QUORUM)
+		assert.Nil(t, err)
+		assert.Equal(t, "v2", string(resp.Value))
+		assert.Equal(t, newest.Version, resp.Version)
+		assert.True(t, resp.Divergent)
+	})
+
+	t.Run("conditional writes expect the newest version of the replicas", func(t *testing.T) {
+		newest, _, _ := pred.s.store.Get(key)
+		// the owner missed the last write
+		assert.Nil(t, owner.s.store.Put(storage.Item{Key: key, Value: []byte("v0"), Version: newest.Version - 10}))
+
+		c, close := owner.getClient()
+		defer close()
+		_, err := c.Put(context.Background(), &pb.PutRequest{
+			Key:             key,
+			Value:           []byte("v3"),
+			Consistency:     pb.Consistency_QUORUM,
+			Conditional:     true,
+			ExpectedVersion: newest.Version,
+		})
+		assert.Nil(t, err)
+	})
+
+	t.Run("requests fail without enough replicas", func(t *testing.T) {
+		succ.crash()
+
+		assert.Nil(t, owner.put(key, "v3", pb.Consistency_QUORUM))
+		err := owner.put(key, "v4", pb.Consistency_ALL)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+
+		// a write failing for want of replicas isn't rolled back on those that stored it
+		resp, err := owner.get(key, pb.Consistency_QUORUM)
+		assert.Nil(t, err)
+		assert.Equal(t, "v4", string(resp.Value))
+		_, err = owner.get(key, pb.Consistency_ALL)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestReplicationFactor(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("the acknowledgements required are counted over the replication factor", func(t *testing.T) {
+		localNode, _ := node.NewLocal(0, inprocAddr(0), 3, node.WithSuccessorListLength(3))
+		// the successor list is reset to a new successor, the next replicas are unknown until it's refreshed
+		assert.Nil(t, localNode.SetSuccNode(ctx, &PBNodeRef{Id: 4, Bind: inprocAddr(4)}))
+		r := newReplicator(localNode, node.NewDialer(nil, node.RPCPolicy{}), storage.NewMemory(), 3)
+		assert.Equal(t, 3, r.replicationFactor())
+
+		_, err := r.write(ctx, storage.Item{Key: "a"}, pb.Consistency_ALL, nil)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("a ring smaller than the replication factor has fewer replicas", func(t *testing.T) {
+		n0 := newReplicatedNode(0, 3, 3)
+		n4 := newReplicatedNode(4, 3, 3)
+		defer n0.stop()
+		defer n4.stop()
+		n4.join(n0)
+		stabilizeRounds(3, n0, n4)
+
+		assert.Equal(t, 2, n0.s.replicator.replicationFactor())
+		assert.Nil(t, n0.put("a", "v1", pb.Consistency_ALL))
+	})
+}
@@ -0,0 +1,99 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("a deleted key is replaced by a tombstone on every replica", func(t *testing.T) {
+		pair := newReplicatedPair()
+		defer pair.stop()
+		n0, n4 := pair.n0, pair.n4
+
+		c, close := n0.getClient()
+		defer close()
+		assert.Nil(t, n0.put("session", "a", pb.Consistency_ALL))
+		stale, _, _ := n0.s.store.Get("session")
+
+		resp, err := c.Delete(ctx, &pb.DeleteRequest{Key: "session", Consistency: pb.Consistency_ALL})
+		assert.Nil(t, err)
+		owner, replica := pair.ownerOf("session")
+		assert.Equal(t, owner.id, resp.Owner.GetId())
+		for _, n := range []testNode{n0, n4} {
+			item, found, _ := n.s.store.Get("session")
+			assert.True(t, found)
+			assert.True(t, item.Deleted)
+			assert.Equal(t, resp.Version, item.Version)
+		}
+
+		get, err := n0.get("session", pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.False(t, get.Found)
+
+		// a replica that missed the delete reads as divergent, and can't hand the old value back
+		assert.Nil(t, replica.s.store.Put(stale))
+		get, err = n0.get("session", pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.False(t, get.Found)
+		assert.True(t, get.Divergent)
+
+		ownerClient, closeOwner := owner.getClient()
+		defer closeOwner()
+		_, err = ownerClient.Replicate(ctx, &pb.ReplicateRequest{Items: []*pb.KeyValue{itemToProto(stale)}})
+		assert.Nil(t, err)
+		get, err = n0.get("session", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.False(t, get.Found)
+	})
+
+	t.Run("a deleted key can be written again", func(t *testing.T) {
+		n := newReplicatedNode(0, 3, 1)
+		defer n.stop()
+		c, close := n.getClient()
+		defer close()
+
+		assert.Nil(t, n.put("session", "a", pb.Consistency_ONE))
+		_, err := c.Delete(ctx, &pb.DeleteRequest{Key: "session"})
+		assert.Nil(t, err)
+
+		// the tombstone is as good as absent
+		_, err = c.Put(ctx, &pb.PutRequest{Key: "session", Value: []byte("b"), Conditional: true})
+		assert.Nil(t, err)
+		get, err := n.get("session", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.Equal(t, "b", string(get.Value))
+	})
+
+	t.Run("tombstones are reaped after the grace period", func(t *testing.T) {
+		n := newNodeWithConfig(Config{
+			ID:   0,
+			M:    3,
+			Bind: inprocAddr(0),
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+			Storage: StorageConfig{
+				ReapPeriod:           10 * time.Millisecond,
+				TombstoneGracePeriod: 50 * time.Millisecond,
+			},
+		})
+		defer n.stop()
+		c, close := n.getClient()
+		defer close()
+
+		assert.Nil(t, n.put("session", "a", pb.Consistency_ONE))
+		_, err := c.Delete(ctx, &pb.DeleteRequest{Key: "session"})
+		assert.Nil(t, err)
+		assert.True(t, n.stores("session"))
+
+		assert.Eventually(t, func() bool {
+			return !n.stores("session")
+		}, time.Second, 10*time.Millisecond)
+	})
+}
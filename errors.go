@@ -1,9 +1,23 @@
 package chordio
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
 
 var (
-	errInvalidBindFormat = errors.New("bind must be of the form '[IP]:<port>'")
-	errInvalidBindIP     = errors.New("the requested bind IP address is invalid")
-	errUnableToGetBindIP = errors.New("unable to get a bind IP")
+	errInvalidBindFormat    = errors.New("must be of the form '[host]:<port>', with IPv6 hosts in brackets, or 'unix://<path>'")
+	errUnableToGetBindIP    = errors.New("unable to get a bind IP")
+	errUnknownAdvertisePort = errors.New("cannot be derived from a listen address with port 0")
+	errServerStarted        = errors.New("the server can only be run once")
 )
+
+// ConfigError is returned for an invalid config, Field is the key of the offending field in the config file
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid config %s: %s", e.Field, e.Reason)
+}
@@ -0,0 +1,133 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("an expired key is hidden before it's reaped", func(t *testing.T) {
+		n := newReplicatedNode(0, 3, 1)
+		defer n.stop()
+		c, close := n.getClient()
+		defer close()
+
+		_, err := c.Put(ctx, &pb.PutRequest{Key: "session", Value: []byte("a"), TtlMillis: 50})
+		assert.Nil(t, err)
+		get, err := n.get("session", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.True(t, get.Found)
+		assert.NotZero(t, get.ExpiresAt)
+
+		time.Sleep(100 * time.Millisecond)
+		get, err = n.get("session", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.False(t, get.Found)
+		assert.Empty(t, get.Value)
+		assert.True(t, n.stores("session"))
+
+		// the expired key is as good as absent
+		_, err = c.Put(ctx, &pb.PutRequest{Key: "session", Value: []byte("b"), Conditional: true})
+		assert.Nil(t, err)
+		get, err = n.get("session", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.Equal(t, "b", string(get.Value))
+		assert.Zero(t, get.ExpiresAt)
+	})
+
+	t.Run("the TTL must not be negative", func(t *testing.T) {
+		n := newReplicatedNode(0, 3, 1)
+		defer n.stop()
+		c, close := n.getClient()
+		defer close()
+
+		_, err := c.Put(ctx, &pb.PutRequest{Key: "session", TtlMillis: -1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("expired keys are reaped", func(t *testing.T) {
+		n := newNodeWithConfig(Config{
+			ID:   0,
+			M:    3,
+			Bind: inprocAddr(0),
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+			Storage: StorageConfig{
+				ReapPeriod:           10 * time.Millisecond,
+				TombstoneGracePeriod: 50 * time.Millisecond,
+			},
+		})
+		defer n.stop()
+		c, close := n.getClient()
+		defer close()
+
+		_, err := c.Put(ctx, &pb.PutRequest{Key: "session", TtlMillis: 50})
+		assert.Nil(t, err)
+		_, err = c.Put(ctx, &pb.PutRequest{Key: "config"})
+		assert.Nil(t, err)
+
+		assert.Eventually(t, func() bool {
+			return !n.stores("session")
+		}, time.Second, 10*time.Millisecond)
+		assert.True(t, n.stores("config"))
+	})
+
+	t.Run("expired values are reaped as tombstones, so that older copies can't bring them back", func(t *testing.T) {
+		n := newReplicatedNode(0, 3, 1)
+		defer n.stop()
+		c, close := n.getClient()
+		defer close()
+
+		_, err := c.Put(ctx, &pb.PutRequest{Key: "session", Value: []byte("a")})
+		assert.Nil(t, err)
+		older, _, _ := n.s.store.Get("session")
+		_, err = c.Put(ctx, &pb.PutRequest{Key: "session", Value: []byte("b"), TtlMillis: 10})
+		assert.Nil(t, err)
+		written, _, _ := n.s.store.Get("session")
+
+		numReaped, err := n.s.reap(written.ExpiresAt)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, numReaped)
+		tombstone, _, _ := n.s.store.Get("session")
+		assert.True(t, tombstone.Deleted)
+		assert.Equal(t, written.Version, tombstone.Version)
+		assert.True(t, tombstone.ExpiresAt.Equal(written.ExpiresAt.Add(n.s.tombstoneGracePeriod)))
+
+		// a replica that missed the write with the TTL hands the older copy back
+		assert.Nil(t, n.s.replicator.merge(older))
+		get, err := n.get("session", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.False(t, get.Found)
+
+		numReaped, err = n.s.reap(tombstone.ExpiresAt)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, numReaped)
+		assert.False(t, n.stores("session"))
+	})
+
+	t.Run("the replicas agree on the expiry time", func(t *testing.T) {
+		pair := newReplicatedPair()
+		defer pair.stop()
+		n0, n4 := pair.n0, pair.n4
+
+		c, close := n0.getClient()
+		defer close()
+		_, err := c.Put(ctx, &pb.PutRequest{Key: "presence", TtlMillis: time.Hour.Milliseconds(), Consistency: pb.Consistency_ALL})
+		assert.Nil(t, err)
+
+		item0, found0, _ := n0.s.store.Get("presence")
+		item4, found4, _ := n4.s.store.Get("presence")
+		assert.True(t, found0 && found4)
+		assert.False(t, item0.ExpiresAt.IsZero())
+		assert.True(t, item0.ExpiresAt.Equal(item4.ExpiresAt))
+		assert.True(t, item0.ExpiresAt.After(time.Now().Add(59*time.Minute)))
+	})
+}
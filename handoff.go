@@ -0,0 +1,190 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+)
+
+var (
+	errTooManyHints = status.Error(codes.ResourceExhausted, "too many hints")
+	errNoHintHolder = errors.New("no successor besides the replicas to hand the write off to")
+)
+
+// hints are the writes kept by the local node for the replicas of other nodes that couldn't be reached,
+// to be replayed once they're back. Only the newest write of a key is kept for a replica
+type hints struct {
+	max int
+
+	mu        sync.Mutex
+	size      int
+	byReplica map[string]*replicaHints
+}
+
+// replicaHints are the hints of a replica, by key
+type replicaHints struct {
+	replica chord.NodeRef
+	items   map[string]storage.Item
+}
+
+func newHints(max int) *hints {
+	return &hints{max: max, byReplica: make(map[string]*replicaHints)}
+}
+
+// add keeps the items for the replica, none of them if there would be more than the maximum number of hints
+func (h *hints) add(replica chord.NodeRef, items []storage.Item) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.byReplica[replica.GetBind()]
+	if !ok {
+		rh = &replicaHints{replica: replica, items: make(map[string]storage.Item)}
+	}
+	added := make(map[string]bool)
+	for _, item := range items {
+		if _, ok := rh.items[item.Key]; !ok {
+			added[item.Key] = true
+		}
+	}
+	if h.size+len(added) > h.max {
+		return errTooManyHints
+	}
+
+	for _, item := range items {
+		if current, ok := rh.items[item.Key]; !ok || item.NewerThan(current) {
+			rh.items[item.Key] = item
+		}
+	}
+	h.byReplica[replica.GetBind()] = rh
+	h.size += len(added)
+	return nil
+}
+
+// replay copies the hints to their replicas through r, those that can be reached again, and drops the hints
+// they stored. Returns how many hints were replayed
+func (h *hints) replay(ctx context.Context, r *replicator) (int, error) {
+	h.mu.Lock()
+	pending := make(map[chord.NodeRef][]storage.Item, len(h.byReplica))
+	for _, rh := range h.byReplica {
+		for _, item := range rh.items {
+			pending[rh.replica] = append(pending[rh.replica], item)
+		}
+	}
+	h.mu.Unlock()
+
+	var (
+		numReplayed int
+		lastErr     error
+	)
+	for replica, items := range pending {
+		if err := r.pushItems(ctx, replica, items); err != nil {
+			if !node.IsTransient(err) {
+				lastErr = errors.Wrapf(err, "unable to replay the hints of %s", replica)
+			}
+			// still down
+			continue
+		}
+		numReplayed += h.drop(replica, items)
+	}
+	return numReplayed, lastErr
+}
+
+// drop removes the hints of the replica that were replayed, unless a newer write replaced them since
+func (h *hints) drop(replica chord.NodeRef, replayed []storage.Item) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rh, ok := h.byReplica[replica.GetBind()]
+	if !ok {
+		// a concurrent replay dropped them first
+		return 0
+	}
+	var numDropped int
+	for _, item := range replayed {
+		if current, ok := rh.items[item.Key]; ok && !current.NewerThan(item) {
+			delete(rh.items, item.Key)
+			numDropped++
+		}
+	}
+	h.size -= numDropped
+	if len(rh.items) == 0 {
+		delete(h.byReplica, replica.GetBind())
+	}
+	return numDropped
+}
+
+// hintHolder is the first successor that isn't a replica, which keeps the writes missed by the replicas
+// that are down. The successor list has one more node than the replicas unless the ring is smaller
+func (r *replicator) hintHolder() chord.NodeRef {
+	replicas := r.currentReplicas()
+	for _, succ := range r.localNode.GetSuccessorList() {
+		if succ.GetID() == r.localNode.GetID() {
+			break
+		}
+		if !containsNode(replicas, succ) {
+			return succ
+		}
+	}
+	return nil
+}
+
+// handOff stores the items missed by the replica, suspected down, as hints on the hint holder
+func (r *replicator) handOff(ctx context.Context, replica chord.NodeRef, items []storage.Item) error {
+	holder := r.hintHolder()
+	if holder == nil {
+		return errNoHintHolder
+	}
+	batches, large := batch(items)
+	for _, item := range large {
+		if err := r.pushItem(ctx, holder.GetBind(), item, replica); err != nil {
+			return errors.Wrapf(err, "unable to hand off to %s", holder)
+		}
+	}
+	for _, b := range batches {
+		req := &pb.StoreHintsRequest{Replica: asProtobufRef(replica), Items: b}
+		err := r.dialer.Call(ctx, holder.GetBind(), "StoreHints", func(ctx context.Context, client pb.ChordClient) error {
+			_, err := client.StoreHints(ctx, req)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to hand off to %s", holder)
+		}
+	}
+	logrus.Infof("%s is unreachable, handed %d keys off to %s", replica, len(items), holder)
+	return nil
+}
+
+func containsNode(nodes []chord.NodeRef, n chord.NodeRef) bool {
+	for _, other := range nodes {
+		if other.GetID() == n.GetID() {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreHints keeps the copies of keys missed by a replica that couldn't be reached by their owner,
+// until they're replayed to the replica. Fails with ResourceExhausted if the local node keeps too many hints
+func (s *Server) StoreHints(_ context.Context, req *pb.StoreHintsRequest) (*pb.StoreHintsResponse, error) {
+	logger := logrus.WithField("method", "Server.StoreHints")
+	logger.Debugf("replica=%v items=%d", req.Replica, len(req.Items))
+
+	if req.Replica == nil {
+		return nil, status.Error(codes.InvalidArgument, "the replica must be set")
+	}
+	items := make([]storage.Item, 0, len(req.Items))
+	for _, kv := range req.Items {
+		items = append(items, itemFromProto(kv))
+	}
+	if err := s.hints.add((*PBNodeRef)(req.Replica), items); err != nil {
+		return nil, err
+	}
+	return &pb.StoreHintsResponse{}, nil
+}
@@ -0,0 +1,84 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestHints(t *testing.T) {
+	replica := &PBNodeRef{Id: 1, Bind: "inproc://replica"}
+	h := newHints(2)
+
+	assert.Nil(t, h.add(replica, []storage.Item{{Key: "a", Version: 1}, {Key: "b", Version: 1}}))
+	// a newer write of a key replaces its hint
+	assert.Nil(t, h.add(replica, []storage.Item{{Key: "a", Version: 2}}))
+	assert.Equal(t, uint64(2), h.byReplica[replica.Bind].items["a"].Version)
+
+	err := h.add(replica, []storage.Item{{Key: "c", Version: 1}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 2, h.size)
+
+	assert.Equal(t, 1, h.drop(replica, []storage.Item{{Key: "a", Version: 1}, {Key: "b", Version: 1}}))
+	assert.Equal(t, 1, h.size)
+
+	// concurrent replays may drop the same hints
+	assert.Equal(t, 1, h.drop(replica, []storage.Item{{Key: "a", Version: 2}}))
+	assert.Zero(t, h.drop(replica, []storage.Item{{Key: "a", Version: 2}}))
+	assert.Zero(t, h.size)
+}
+
+func TestHintedHandoff(t *testing.T) {
+	nodes := map[uint64]testNode{}
+	for _, id := range []int{0, 2, 4, 6} {
+		nodes[uint64(id)] = newReplicatedNode(id, 3, 2)
+	}
+	for _, id := range []uint64{2, 4, 6} {
+		nodes[id].join(nodes[0])
+	}
+	stabilizeRounds(4, nodes[0], nodes[2], nodes[4], nodes[6])
+
+	const key = "presence"
+	resp, err := nodes[0].get(key, pb.Consistency_ONE)
+	assert.Nil(t, err)
+	owner := nodes[resp.Owner.Id]
+	replica := nodes[owner.status().Node.Succ.Id]
+	holder := nodes[replica.status().Node.Succ.Id]
+	for id, n := range nodes {
+		if id != replica.id {
+			defer n.stop()
+		}
+	}
+
+	replica.crash()
+	// the hint doesn't stand in for the replica
+	err = owner.put(key, "online", pb.Consistency_ALL)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.False(t, holder.stores(key))
+	assert.Equal(t, 1, holder.s.hints.size)
+
+	t.Run("hints are kept until the replica is back", func(t *testing.T) {
+		numReplayed, err := holder.s.hints.replay(context.Background(), holder.s.replicator)
+		assert.Nil(t, err)
+		assert.Zero(t, numReplayed)
+		assert.Equal(t, 1, holder.s.hints.size)
+	})
+
+	t.Run("hints are replayed once the replica is back", func(t *testing.T) {
+		replica = newNodeAt(int(replica.id), 3, replica.addr)
+		defer replica.stop()
+
+		// the replica may not be serving yet
+		assert.Eventually(t, func() bool {
+			numReplayed, err := holder.s.hints.replay(context.Background(), holder.s.replicator)
+			return err == nil && numReplayed == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Zero(t, holder.s.hints.size)
+		assert.True(t, replica.stores(key))
+	})
+}
@@ -0,0 +1,111 @@
+package chordio
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"sync"
+)
+
+// chordServiceName is the fully qualified name of the Chord grpc service
+// as reported through the health protocol
+const chordServiceName = "Chord"
+
+// readiness tracks whether the node is ready to take traffic.
+// A node is ready once it has joined a ring and completed one stabilization,
+// and stops being ready as soon as it starts leaving.
+// A node started without seeds has joined once another node joined its ring and notified it:
+// alone in its ring, it's never ready.
+type readiness struct {
+	mu           *sync.Mutex
+	healthServer *health.Server
+	joined       bool
+	stabilized   bool
+	leaving      bool
+}
+
+func (r *readiness) setJoined() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.joined = true
+	r.update()
+}
+
+func (r *readiness) setStabilized() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.joined {
+		// stabilizing a ring of one does not make the node ready
+		return
+	}
+	r.stabilized = true
+	r.update()
+}
+
+func (r *readiness) setLeaving() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leaving = true
+	// Shutdown sets every service to NOT_SERVING and ignores later updates
+	r.healthServer.Shutdown()
+}
+
+func (r *readiness) update() {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if r.joined && r.stabilized && !r.leaving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	r.healthServer.SetServingStatus("", status)
+	r.healthServer.SetServingStatus(chordServiceName, status)
+}
+
+func (r *readiness) status() healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := r.healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN
+	}
+	return resp.Status
+}
+
+func newReadiness() *readiness {
+	r := &readiness{
+		mu:           new(sync.Mutex),
+		healthServer: health.NewServer(),
+	}
+	r.update()
+	return r
+}
+
+// newHealthHTTPHandler exposes the health state over HTTP for load balancers and orchestrators:
+//
+//	/healthz - liveness, always 200 while the process is serving requests
+//	/readyz  - readiness, 200 if the node is SERVING, 503 otherwise
+func newHealthHTTPHandler(r *readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		status := r.status()
+		if status != healthpb.HealthCheckResponse_SERVING {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		fmt.Fprintln(w, status.String())
+	})
+	return mux
+}
+
+func (s *Server) serveHealthHTTP() error {
+	logrus.Info("serving health endpoints at: ", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "health endpoint failed")
+	}
+	return nil
+}
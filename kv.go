@@ -0,0 +1,236 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+var (
+	errEmptyKey    = status.Error(codes.InvalidArgument, "the key must not be empty")
+	errNegativeTTL = status.Error(codes.InvalidArgument, "the TTL must not be negative")
+	errReservedKey = status.Errorf(codes.InvalidArgument, "the keys starting with %q are reserved for the blocks", blockKeyPrefix)
+)
+
+// checkWritable rejects the keys Put, Delete and PutStream can't write: the blocks are immutable
+func checkWritable(key string) error {
+	if key == "" {
+		return errEmptyKey
+	}
+	if strings.HasPrefix(key, blockKeyPrefix) {
+		return errReservedKey
+	}
+	return nil
+}
+
+func asProtobufRef(n chord.NodeRef) *pb.Node {
+	return &pb.Node{Id: n.GetID().AsU64(), Bind: n.GetBind()}
+}
+
+// owner of the key, the successor of its ID
+func (s *Server) owner(ctx context.Context, key string) (chord.Node, error) {
+	return s.localNode.FindSuccessor(ctx, keyID(key, s.localNode.GetRank()))
+}
+
+// forwardToOwner calls forward with the owner of the key unless the request was already forwarded
+// or the key is owned by the local node. It reports whether the request was handled,
+// by forward or by failing to find the owner, with the error of the request
+func (s *Server) forwardToOwner(ctx context.Context, key string, forwarded bool, forward func(owner chord.NodeRef) error) (bool, error) {
+	if forwarded {
+		return false, nil
+	}
+	owner, err := s.owner(ctx, key)
+	if err != nil {
+		return true, err
+	}
+	if owner.GetID() == s.localNode.GetID() {
+		return false, nil
+	}
+	return true, forward(owner)
+}
+
+// Put stores the value of the key on its owner, which copies it to its replicas.
+// It returns once as many replicas as the consistency level requires stored it,
+// a write failing for want of replicas may still have been stored by some of them.
+// A conditional Put fails with FailedPrecondition unless the key is at the expected version.
+// The expiry time of a key with a TTL is set by the owner, so that all the replicas agree on it
+func (s *Server) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	logger := logrus.WithField("method", "Server.Put")
+	logger.Debugf("key=%s", req.Key)
+
+	if err := checkWritable(req.Key); err != nil {
+		return nil, err
+	}
+	if req.TtlMillis < 0 {
+		return nil, errNegativeTTL
+	}
+
+	var resp *pb.PutResponse
+	if forwarded, err := s.forwardToOwner(ctx, req.Key, req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Call(ctx, owner.GetBind(), "Put", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.Put(ctx, &pb.PutRequest{
+				Key:             req.Key,
+				Value:           req.Value,
+				Forwarded:       true,
+				Consistency:     req.Consistency,
+				Conditional:     req.Conditional,
+				ExpectedVersion: req.ExpectedVersion,
+				TtlMillis:       req.TtlMillis,
+			})
+			return err
+		})
+	}); forwarded {
+		return resp, err
+	}
+
+	var expectedVersion *uint64
+	if req.Conditional {
+		expectedVersion = &req.ExpectedVersion
+	}
+	item := storage.Item{Key: req.Key, Value: req.Value}
+	if req.TtlMillis > 0 {
+		item.ExpiresAt = time.Now().Add(time.Duration(req.TtlMillis) * time.Millisecond)
+	}
+	item, err := s.replicator.write(ctx, item, req.Consistency, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.PutResponse{Owner: asProtobufRef(s.localNode), Version: item.Version}, nil
+}
+
+// Get reads the value of the key from its owner and as many replicas as the consistency level requires.
+// A deleted or expired key isn't found, even before it's reaped
+func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	logger := logrus.WithField("method", "Server.Get")
+	logger.Debugf("key=%s", req.Key)
+
+	if req.Key == "" {
+		return nil, errEmptyKey
+	}
+
+	var resp *pb.GetResponse
+	if forwarded, err := s.forwardToOwner(ctx, req.Key, req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Call(ctx, owner.GetBind(), "Get", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.Get(ctx, &pb.GetRequest{Key: req.Key, Forwarded: true, Consistency: req.Consistency})
+			return err
+		})
+	}); forwarded {
+		return resp, err
+	}
+
+	read, divergent, err := s.replicator.read(ctx, req.Key, req.Consistency)
+	if err != nil {
+		return nil, err
+	}
+	resp = &pb.GetResponse{
+		Owner:     asProtobufRef(s.localNode),
+		Divergent: divergent,
+	}
+	if read.found && read.item.Live(time.Now()) {
+		kv := itemToProto(read.item)
+		resp.Found, resp.Value, resp.Version, resp.ExpiresAt = true, kv.Value, kv.Version, kv.ExpiresAt
+	}
+	return resp, nil
+}
+
+// Delete replaces the value of the key by a tombstone on its owner, which copies it to its replicas
+// like a value, so that a replica that missed the delete can't bring the value back. The tombstone
+// is reaped once the grace period passed. Conditional deletes and consistency levels are those of Put
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	logger := logrus.WithField("method", "Server.Delete")
+	logger.Debugf("key=%s", req.Key)
+
+	if err := checkWritable(req.Key); err != nil {
+		return nil, err
+	}
+
+	var resp *pb.DeleteResponse
+	if forwarded, err := s.forwardToOwner(ctx, req.Key, req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Call(ctx, owner.GetBind(), "Delete", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.Delete(ctx, &pb.DeleteRequest{
+				Key:             req.Key,
+				Forwarded:       true,
+				Consistency:     req.Consistency,
+				Conditional:     req.Conditional,
+				ExpectedVersion: req.ExpectedVersion,
+			})
+			return err
+		})
+	}); forwarded {
+		return resp, err
+	}
+
+	var expectedVersion *uint64
+	if req.Conditional {
+		expectedVersion = &req.ExpectedVersion
+	}
+	tombstone := storage.Item{
+		Key:       req.Key,
+		Deleted:   true,
+		ExpiresAt: time.Now().Add(s.tombstoneGracePeriod),
+	}
+	tombstone, err := s.replicator.write(ctx, tombstone, req.Consistency, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DeleteResponse{Owner: asProtobufRef(s.localNode), Version: tombstone.Version}, nil
+}
+
+// Replicate stores the copies of the keys sent by their owner, or by their previous owner
+func (s *Server) Replicate(_ context.Context, req *pb.ReplicateRequest) (*pb.ReplicateResponse, error) {
+	logger := logrus.WithField("method", "Server.Replicate")
+	logger.Debugf("items=%d", len(req.Items))
+
+	for _, kv := range req.Items {
+		// a late copy must not replace a newer write
+		if err := s.replicator.merge(itemFromProto(kv)); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.ReplicateResponse{}, nil
+}
+
+// GetReplica reads the copy of the key stored on the local node for its owner, even if it's a tombstone or expired.
+// A value too large for a single message is left out, to be read by GetReplicaStream
+func (s *Server) GetReplica(_ context.Context, req *pb.GetReplicaRequest) (*pb.GetReplicaResponse, error) {
+	item, found, err := s.store.Get(req.Key)
+	if err != nil || !found {
+		return &pb.GetReplicaResponse{}, err
+	}
+	if len(item.Value) > replicationBatchSize {
+		item.Value = nil
+		return &pb.GetReplicaResponse{Item: itemToProto(item), Found: true, Chunked: true}, nil
+	}
+	return &pb.GetReplicaResponse{Item: itemToProto(item), Found: true}, nil
+}
+
+// GetReplicas reads the copies of the keys stored on the local node for its owner, like GetReplica.
+// The values are left out once the response holds more than a single message's worth of them
+func (s *Server) GetReplicas(_ context.Context, req *pb.GetReplicasRequest) (*pb.GetReplicasResponse, error) {
+	replicas := make([]*pb.GetReplicaResponse, len(req.Keys))
+	size := 0
+	for i, key := range req.Keys {
+		item, found, err := s.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			replicas[i] = &pb.GetReplicaResponse{}
+			continue
+		}
+		if size+len(item.Value) > replicationBatchSize {
+			item.Value = nil
+			replicas[i] = &pb.GetReplicaResponse{Item: itemToProto(item), Found: true, Chunked: true}
+			continue
+		}
+		size += len(item.Value)
+		replicas[i] = &pb.GetReplicaResponse{Item: itemToProto(item), Found: true}
+	}
+	return &pb.GetReplicasResponse{Replicas: replicas}, nil
+}
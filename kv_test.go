@@ -0,0 +1,81 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConditionalPut(t *testing.T) {
+	n := newReplicatedNode(0, 3, 1)
+	defer n.stop()
+	c, close := n.getClient()
+	defer close()
+	ctx := context.Background()
+
+	cas := func(key, value string, expectedVersion uint64) (*pb.PutResponse, error) {
+		return c.Put(ctx, &pb.PutRequest{Key: key, Value: []byte(value), Conditional: true, ExpectedVersion: expectedVersion})
+	}
+
+	t.Run("the expected version 0 creates an absent key", func(t *testing.T) {
+		resp, err := cas("lease", "a", 0)
+		assert.Nil(t, err)
+		assert.NotZero(t, resp.Version)
+
+		_, err = cas("lease", "b", 0)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("the value is only replaced at the expected version", func(t *testing.T) {
+		put, err := c.Put(ctx, &pb.PutRequest{Key: "config", Value: []byte("a")})
+		assert.Nil(t, err)
+
+		_, err = cas("config", "b", put.Version-1)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		resp, err := cas("config", "b", put.Version)
+		assert.Nil(t, err)
+		assert.True(t, resp.Version > put.Version)
+
+		get, err := n.get("config", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.Equal(t, "b", string(get.Value))
+		assert.Equal(t, resp.Version, get.Version)
+	})
+
+	t.Run("concurrent increments of a counter aren't lost", func(t *testing.T) {
+		increment := func() {
+			for {
+				get, err := n.get("counter", pb.Consistency_ONE)
+				if err != nil {
+					panic(err)
+				}
+				count, _ := strconv.Atoi(string(get.Value))
+				_, err = cas("counter", strconv.Itoa(count+1), get.Version)
+				if status.Code(err) != codes.FailedPrecondition {
+					assert.Nil(t, err)
+					return
+				}
+			}
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				increment()
+			}()
+		}
+		wg.Wait()
+
+		get, err := n.get("counter", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.Equal(t, "10", string(get.Value))
+	})
+}
@@ -0,0 +1,92 @@
+package chordio
+
+import (
+	"bytes"
+	"context"
+	"github.com/golang/protobuf/proto"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// manifestMagic starts the manifest blocks, telling them apart from the data blocks
+const manifestMagic = "chordio/manifest/1\n"
+
+// PutFile stores the content read from r in blocks of MaxBlockSize, spread over the owners of their IDs,
+// then their manifest. Returns the ID of the manifest, which addresses the file
+func PutFile(ctx context.Context, client pb.ChordClient, r io.Reader, level pb.Consistency) ([]byte, error) {
+	manifest := &pb.Manifest{}
+	buf := make([]byte, MaxBlockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		resp, err := client.PutBlock(ctx, &pb.PutBlockRequest{Data: buf[:n], Consistency: level})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to store block %d", len(manifest.Blocks))
+		}
+		manifest.Blocks = append(manifest.Blocks, resp.Id)
+		manifest.Size += uint64(n)
+	}
+
+	data, err := proto.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	data = append([]byte(manifestMagic), data...)
+	if len(data) > MaxBlockSize {
+		return nil, errors.Errorf("the manifest of %d blocks is larger than a block", len(manifest.Blocks))
+	}
+	resp, err := client.PutBlock(ctx, &pb.PutBlockRequest{Data: data, Consistency: level})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to store the manifest")
+	}
+	return resp.Id, nil
+}
+
+// GetFile writes the file addressed by the ID of its manifest to w, block by block.
+// Every block is verified against its ID, on the owner and again once received
+func GetFile(ctx context.Context, client pb.ChordClient, id []byte, w io.Writer, level pb.Consistency) error {
+	getBlock := func(id []byte) ([]byte, error) {
+		resp, err := client.GetBlock(ctx, &pb.GetBlockRequest{Id: id, Consistency: level})
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(BlockID(resp.Data), id) {
+			return nil, errors.Errorf("block %x is corrupted", id)
+		}
+		return resp.Data, nil
+	}
+
+	data, err := getBlock(id)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the manifest")
+	}
+	if !bytes.HasPrefix(data, []byte(manifestMagic)) {
+		return errors.Errorf("block %x isn't a manifest", id)
+	}
+	var manifest pb.Manifest
+	if err := proto.Unmarshal(data[len(manifestMagic):], &manifest); err != nil {
+		return errors.Wrapf(err, "unable to decode manifest %x", id)
+	}
+
+	var size uint64
+	for i, blockID := range manifest.Blocks {
+		data, err := getBlock(blockID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read block %d", i)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		size += uint64(len(data))
+	}
+	if size != manifest.Size {
+		return errors.Errorf("the file is %d bytes, the manifest says %d", size, manifest.Size)
+	}
+	return nil
+}
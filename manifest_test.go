@@ -0,0 +1,64 @@
+package chordio
+
+import (
+	"bytes"
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	ctx := context.Background()
+	nodes := []testNode{newReplicatedNode(0, 3, 2), newReplicatedNode(3, 3, 2), newReplicatedNode(6, 3, 2)}
+	for _, n := range nodes {
+		defer n.stop()
+	}
+	nodes[1].join(nodes[0])
+	nodes[2].join(nodes[0])
+	stabilizeRounds(4, nodes...)
+
+	c, close := nodes[0].getClient()
+	defer close()
+
+	file := make([]byte, 3*MaxBlockSize+MaxBlockSize/2)
+	rand.New(rand.NewSource(1)).Read(file)
+
+	t.Run("a file is stored as blocks and read back by its manifest", func(t *testing.T) {
+		id, err := PutFile(ctx, c, bytes.NewReader(file), pb.Consistency_ALL)
+		assert.Nil(t, err)
+
+		var out bytes.Buffer
+		assert.Nil(t, GetFile(ctx, c, id, &out, pb.Consistency_ONE))
+		assert.True(t, bytes.Equal(file, out.Bytes()))
+	})
+
+	t.Run("the blocks are spread over the owners of their IDs", func(t *testing.T) {
+		owners := map[uint64]bool{}
+		for offset := 0; offset < len(file); offset += MaxBlockSize {
+			end := offset + MaxBlockSize
+			if end > len(file) {
+				end = len(file)
+			}
+			resp, err := c.PutBlock(ctx, &pb.PutBlockRequest{Data: file[offset:end]})
+			assert.Nil(t, err)
+			owners[resp.Owner.Id] = true
+		}
+		assert.True(t, len(owners) > 1)
+	})
+
+	t.Run("an empty file", func(t *testing.T) {
+		id, err := PutFile(ctx, c, bytes.NewReader(nil), pb.Consistency_ONE)
+		assert.Nil(t, err)
+		var out bytes.Buffer
+		assert.Nil(t, GetFile(ctx, c, id, &out, pb.Consistency_ONE))
+		assert.Zero(t, out.Len())
+	})
+
+	t.Run("a data block isn't a manifest", func(t *testing.T) {
+		resp, err := c.PutBlock(ctx, &pb.PutBlockRequest{Data: []byte("data")})
+		assert.Nil(t, err)
+		assert.NotNil(t, GetFile(ctx, c, resp.Id, &bytes.Buffer{}, pb.Consistency_ONE))
+	})
+}
@@ -0,0 +1,139 @@
+package chordio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/storage"
+	"sort"
+)
+
+const (
+	// merkleDepth is the depth of the Merkle trees compared by anti-entropy, with 64 leaves
+	merkleDepth = 6
+	// maxMerkleDepth bounds the trees built for the peers
+	maxMerkleDepth = 16
+)
+
+// merkleTree hashes the items of a key range bucketed by sub-ranges, the leaves, so that two replicas
+// find the sub-ranges they disagree on by comparing a few hashes. An item is hashed by its key, version
+// and whether it's a tombstone, the version of a key identifies its value
+type merkleTree struct {
+	leaves []chord.Interval
+	// levels of hashes from the root, levels[0], down to the leaves.
+	// The parent of the nodes 2i and 2i+1 of a level is the node i of the level above
+	levels [][][]byte
+}
+
+// newMerkleTree builds the tree of the items of the range (iv.Start, iv.End] split into 2**depth leaves,
+// or fewer if the range is narrower. The items out of the range are ignored
+func newMerkleTree(m chord.Rank, iv chord.Interval, depth int, items []storage.Item) *merkleTree {
+	leaves := iv.Split(1 << uint(depth))
+	buckets := make([][]storage.Item, len(leaves))
+	for _, item := range items {
+		if i := leafOf(m, iv, leaves, keyID(item.Key, m)); i >= 0 {
+			buckets[i] = append(buckets[i], item)
+		}
+	}
+
+	level := make([][]byte, len(leaves))
+	for i, bucket := range buckets {
+		level[i] = hashItems(bucket)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		parents := make([][]byte, (len(level)+1)/2)
+		for i := range parents {
+			h := sha256.New()
+			h.Write(level[2*i])
+			if 2*i+1 < len(level) {
+				h.Write(level[2*i+1])
+			}
+			parents[i] = h.Sum(nil)
+		}
+		levels = append([][][]byte{parents}, levels...)
+		level = parents
+	}
+	return &merkleTree{leaves: leaves, levels: levels}
+}
+
+// leafOf returns the index of the leaf whose sub-range has the ID, -1 if it's out of the range
+func leafOf(m chord.Rank, iv chord.Interval, leaves []chord.Interval, id chord.ID) int {
+	if !iv.Has(id) {
+		return -1
+	}
+	// the leaves are in ring order, sorted by how far their end is from the start of the range
+	offset := func(id chord.ID) uint64 {
+		if id == iv.Start {
+			// only in the range if it's the whole ring, of which it's the end
+			return 1 << uint(m)
+		}
+		return id.Sub(iv.Start, m).AsU64()
+	}
+	return sort.Search(len(leaves), func(i int) bool {
+		return offset(leaves[i].End) >= offset(id)
+	})
+}
+
+// hashItems hashes the digests of the items in the order of their keys
+func hashItems(items []storage.Item) []byte {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	h := sha256.New()
+	var buf [9]byte
+	for _, item := range items {
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(item.Key)))
+		h.Write(buf[:4])
+		h.Write([]byte(item.Key))
+		binary.BigEndian.PutUint64(buf[:8], item.Version)
+		buf[8] = 0
+		if item.Deleted {
+			buf[8] = 1
+		}
+		h.Write(buf[:])
+	}
+	return h.Sum(nil)
+}
+
+// nodes returns the hashes of the nodes of the level at the indices
+func (t *merkleTree) nodes(level int, indices []int32) ([][]byte, error) {
+	if level < 0 || level >= len(t.levels) {
+		return nil, fmt.Errorf("the tree has no level %d", level)
+	}
+	hashes := make([][]byte, 0, len(indices))
+	for _, i := range indices {
+		if i < 0 || int(i) >= len(t.levels[level]) {
+			return nil, fmt.Errorf("level %d has no node %d", level, i)
+		}
+		hashes = append(hashes, t.levels[level][i])
+	}
+	return hashes, nil
+}
+
+// diff returns the indices of the nodes of the level whose hashes differ from the given ones,
+// which are those of the nodes at the indices
+func (t *merkleTree) diff(level int, indices []int32, hashes [][]byte) []int32 {
+	var differing []int32
+	for k, i := range indices {
+		if k >= len(hashes) || !bytes.Equal(t.levels[level][i], hashes[k]) {
+			differing = append(differing, i)
+		}
+	}
+	return differing
+}
+
+// children returns the indices of the children of the nodes at the indices of the level
+func (t *merkleTree) children(level int, indices []int32) []int32 {
+	var children []int32
+	for _, i := range indices {
+		for _, child := range []int32{2 * i, 2*i + 1} {
+			if int(child) < len(t.levels[level+1]) {
+				children = append(children, child)
+			}
+		}
+	}
+	return children
+}
@@ -0,0 +1,79 @@
+package chordio
+
+import (
+	"fmt"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMerkleTree(t *testing.T) {
+	const m = 8
+	iv := chord.NewInterval(m, 200, 100, chord.WithLeftOpen, chord.WithRightClosed)
+	var items []storage.Item
+	for i := 0; i < 50; i++ {
+		item := storage.Item{Key: fmt.Sprintf("key-%d", i), Value: []byte("a"), Version: 1}
+		if iv.Has(keyID(item.Key, m)) {
+			items = append(items, item)
+		}
+	}
+	changed := func(i int, f func(item *storage.Item)) []storage.Item {
+		copied := append([]storage.Item(nil), items...)
+		f(&copied[i])
+		return copied
+	}
+	leaf := func(tree *merkleTree, key string) int32 {
+		return int32(leafOf(m, iv, tree.leaves, keyID(key, m)))
+	}
+
+	tree := newMerkleTree(m, iv, 3, items)
+	assert.Len(t, tree.leaves, 8)
+	assert.Len(t, tree.levels, 4)
+
+	t.Run("the same items have the same tree, in any order", func(t *testing.T) {
+		reversed := make([]storage.Item, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
+		}
+		assert.Equal(t, tree.levels, newMerkleTree(m, iv, 3, reversed).levels)
+	})
+
+	t.Run("every item is in the leaf of its ID", func(t *testing.T) {
+		for _, item := range items {
+			assert.True(t, tree.leaves[leaf(tree, item.Key)].Has(keyID(item.Key, m)), item.Key)
+		}
+		assert.Equal(t, -1, leafOf(m, iv, tree.leaves, 150))
+	})
+
+	t.Run("a newer version changes the path to its leaf only", func(t *testing.T) {
+		other := newMerkleTree(m, iv, 3, changed(0, func(item *storage.Item) { item.Version++ }))
+
+		indices := []int32{0}
+		for level := range tree.levels {
+			if level > 0 {
+				indices = tree.children(level-1, indices)
+			}
+			hashes, err := other.nodes(level, indices)
+			assert.Nil(t, err)
+			indices = tree.diff(level, indices, hashes)
+			assert.Len(t, indices, 1)
+		}
+		assert.Equal(t, []int32{leaf(tree, items[0].Key)}, indices)
+	})
+
+	t.Run("a tombstone changes the tree, a value of the same version doesn't", func(t *testing.T) {
+		deleted := newMerkleTree(m, iv, 3, changed(0, func(item *storage.Item) { item.Deleted = true }))
+		assert.NotEqual(t, tree.levels[0], deleted.levels[0])
+
+		revalued := newMerkleTree(m, iv, 3, changed(0, func(item *storage.Item) { item.Value = []byte("b") }))
+		assert.Equal(t, tree.levels[0], revalued.levels[0])
+	})
+
+	t.Run("the nodes must exist", func(t *testing.T) {
+		_, err := tree.nodes(4, []int32{0})
+		assert.NotNil(t, err)
+		_, err = tree.nodes(1, []int32{2})
+		assert.NotNil(t, err)
+	})
+}
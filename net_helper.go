@@ -0,0 +1,104 @@
+package chordio
+
+import (
+	"github.com/kevinjqiu/chordio/transport"
+	"net"
+	"strconv"
+)
+
+// splitBind splits an address of the form host:port, [ipv6]:port or :port
+func splitBind(bind string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", 0, errInvalidBindFormat
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, errInvalidBindFormat
+	}
+	return host, port, nil
+}
+
+func isUnspecifiedHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+func getAvailableInterfaces() []net.Interface {
+	var ai = make([]net.Interface, 0, 0)
+	interfaces, _ := net.Interfaces()
+	for _, inf := range interfaces {
+		isBroadcast := (inf.Flags & net.FlagBroadcast) == net.FlagBroadcast
+		isUp := (inf.Flags & net.FlagUp) == net.FlagUp
+		if isBroadcast && isUp {
+			ai = append(ai, inf)
+		}
+	}
+	return ai
+}
+
+// getFirstAvailableBindIP returns the first global unicast IP of the interfaces that are up,
+// IPv6 ones are only picked when there's no IPv4 one, unless preferIPv6 is set
+func getFirstAvailableBindIP(preferIPv6 bool) (net.IP, error) {
+	var v4, v6 net.IP
+	for _, inf := range getAvailableInterfaces() {
+		addrs, err := inf.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				if v4 == nil {
+					v4 = ipNet.IP
+				}
+			} else if v6 == nil {
+				v6 = ipNet.IP
+			}
+		}
+	}
+
+	if preferIPv6 && v6 != nil {
+		return v6, nil
+	}
+	if v4 != nil {
+		return v4, nil
+	}
+	if v6 != nil {
+		return v6, nil
+	}
+	return nil, errUnableToGetBindIP
+}
+
+// advertiseAddr derives the address peers should dial from the address the server listens on.
+// A listen address without a host, or with an unspecified one (0.0.0.0 or ::), advertises
+// the first available IP of the machine. Unix domain sockets and in-process addresses advertise themselves.
+func advertiseAddr(bind string) (string, error) {
+	if transport.IsLocal(bind) {
+		return bind, nil
+	}
+
+	host, port, err := splitBind(bind)
+	if err != nil {
+		return "", err
+	}
+	if port == 0 {
+		return "", errUnknownAdvertisePort
+	}
+	if !isUnspecifiedHost(host) {
+		return bind, nil
+	}
+
+	ip, err := getFirstAvailableBindIP(host == "::")
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
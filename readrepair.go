@@ -0,0 +1,54 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/attrs"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/core"
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// newReadRepairsCounter counts the stale copies written back by read repair with meter,
+// pushed to the tracing exporter by the meter of the process
+func newReadRepairsCounter(meter metric.Meter) metric.Int64Counter {
+	return metric.Must(meter).NewInt64Counter("chordio.read_repairs",
+		metric.WithDescription("the stale copies of keys replaced by the newest one after a read"))
+}
+
+// repairReads writes the newest copy of a key back to the replicas found to be stale by a read,
+// the local node if a replica is nil, in the background. The local copy is repaired right away
+func (r *replicator) repairReads(ctx context.Context, newest storage.Item, stale []chord.NodeRef) {
+	span := trace.SpanFromContext(ctx)
+	for _, replica := range stale {
+		target := replica
+		if target == nil {
+			target = r.localNode
+		}
+		span.AddEvent(ctx, "read repair",
+			core.Key("key").String(newest.Key),
+			core.Key("version").Uint64(newest.Version),
+			attrs.Node("replica", target))
+
+		if replica == nil {
+			if err := r.merge(newest); err != nil {
+				logrus.Warnf("unable to repair %q locally: %s", newest.Key, err)
+				continue
+			}
+			r.readRepairs.Add(ctx, 1, attrs.Node("replica", target))
+			continue
+		}
+		go func(replica chord.NodeRef) {
+			// the repair outlives the read
+			ctx := context.Background()
+			if err := r.pushItems(ctx, replica, []storage.Item{newest}); err != nil {
+				logrus.Warnf("unable to repair %q on %s: %s", newest.Key, replica, err)
+				r.markDirty()
+				return
+			}
+			r.readRepairs.Add(ctx, 1, attrs.Node("replica", replica))
+		}(replica)
+	}
+}
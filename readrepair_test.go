@@ -0,0 +1,120 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/api/metric"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregator"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/batcher/ungrouped"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"testing"
+	"time"
+)
+
+// countReadRepairs gives the nodes a read repair counter that can be collected by the test,
+// returns the number of repairs counted so far
+func countReadRepairs(nodes ...testNode) func() int64 {
+	batcher := ungrouped.New(simple.NewWithExactMeasure(), true)
+	sdk := sdkmetric.New(batcher)
+	counter := newReadRepairsCounter(metric.WrapMeterImpl(sdk, "test"))
+	for _, n := range nodes {
+		n.s.replicator.readRepairs = counter
+	}
+	return func() int64 {
+		sdk.Collect(context.Background())
+		defer batcher.FinishedCollection()
+		var total int64
+		_ = batcher.CheckpointSet().ForEach(func(record export.Record) error {
+			sum, err := record.Aggregator().(aggregator.Sum).Sum()
+			total += sum.AsInt64()
+			return err
+		})
+		return total
+	}
+}
+
+func TestReadRepair(t *testing.T) {
+	pair := newReplicatedPair()
+	defer pair.stop()
+	n0, n4 := pair.n0, pair.n4
+	readRepairs := countReadRepairs(n0, n4)
+
+	c, close := n0.getClient()
+	defer close()
+	ctx := context.Background()
+
+	// put stores the key on both nodes, returns the owner and the replica
+	put := func(key string) (testNode, testNode, storage.Item) {
+		resp, err := c.Put(ctx, &pb.PutRequest{Key: key, Value: []byte("b"), Consistency: pb.Consistency_ALL})
+		assert.Nil(t, err)
+		owner, replica := pair.ownerOf(key)
+		assert.Equal(t, owner.id, resp.Owner.GetId())
+		item, _, _ := owner.s.store.Get(key)
+		return owner, replica, item
+	}
+	older := func(item storage.Item) storage.Item {
+		item.Value, item.Version = []byte("a"), item.Version-1
+		return item
+	}
+	repaired := func(n testNode, newest storage.Item) func() bool {
+		return func() bool {
+			item, found, _ := n.s.store.Get(newest.Key)
+			return found && item.Version == newest.Version
+		}
+	}
+
+	t.Run("a stale replica is repaired", func(t *testing.T) {
+		_, replica, newest := put("stale")
+		assert.Nil(t, replica.s.store.Put(older(newest)))
+
+		get, err := n0.get("stale", pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.True(t, get.Divergent)
+		assert.Equal(t, "b", string(get.Value))
+		assert.Eventually(t, repaired(replica, newest), time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool { return readRepairs() == 1 }, time.Second, 10*time.Millisecond)
+
+		get, err = n0.get("stale", pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.False(t, get.Divergent)
+	})
+
+	t.Run("a replica missing the key is repaired", func(t *testing.T) {
+		_, replica, newest := put("missing")
+		assert.Nil(t, replica.s.store.Delete("missing"))
+
+		get, err := n0.get("missing", pb.Consistency_QUORUM)
+		assert.Nil(t, err)
+		assert.True(t, get.Divergent)
+		assert.Eventually(t, repaired(replica, newest), time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool { return readRepairs() == 2 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("a stale owner is repaired", func(t *testing.T) {
+		owner, _, newest := put("owner")
+		assert.Nil(t, owner.s.store.Put(older(newest)))
+
+		get, err := n0.get("owner", pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.True(t, get.Divergent)
+		assert.Equal(t, "b", string(get.Value))
+		assert.True(t, repaired(owner, newest)())
+		assert.Equal(t, int64(3), readRepairs())
+	})
+
+	t.Run("a read of one copy repairs nothing", func(t *testing.T) {
+		_, replica, newest := put("one")
+		assert.Nil(t, replica.s.store.Put(older(newest)))
+
+		get, err := n0.get("one", pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.False(t, get.Divergent)
+		time.Sleep(50 * time.Millisecond)
+		assert.False(t, repaired(replica, newest)())
+		assert.Equal(t, int64(3), readRepairs())
+	})
+}
@@ -0,0 +1,272 @@
+package chordio
+
+import (
+	"context"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/chord/node"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/kevinjqiu/chordio/telemetry"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/metric"
+	"sync"
+	"time"
+)
+
+// replicationBatchSize bounds the size of the values copied to a node by a single call,
+// well below the default message size limit of grpc
+const replicationBatchSize = 1 << 20
+
+// replicator keeps copies of the keys owned by the local node on its next successors
+type replicator struct {
+	localNode chord.LocalNode
+	dialer    *node.Dialer
+	store     storage.Engine
+	factor    int
+	// clock issues the versions of the writes coordinated by the local node
+	clock *hybridClock
+	// readRepairs counts the copies repaired by the reads
+	readRepairs metric.Int64Counter
+
+	mu sync.Mutex
+	// pred and replicas are the predecessor and the replicas as of the last re-replication
+	pred     chord.NodeRef
+	replicas []chord.NodeRef
+	// dirty is set when a replica missed a write
+	dirty bool
+}
+
+func newReplicator(localNode chord.LocalNode, dialer *node.Dialer, store storage.Engine, factor int) *replicator {
+	return &replicator{
+		localNode:   localNode,
+		dialer:      dialer,
+		store:       store,
+		factor:      factor,
+		clock:       newHybridClock(),
+		readRepairs: newReadRepairsCounter(telemetry.Meter()),
+	}
+}
+
+// keyID is the ID of the key on the ring, that of the key of a block is assigned to the ID of the block
+func keyID(key string, m chord.Rank) chord.ID {
+	if id, ok := blockIDOf(key); ok {
+		return node.AssignID(id, m)
+	}
+	return node.AssignID([]byte(key), m)
+}
+
+// currentReplicas are the nodes storing a copy of the keys owned by the local node,
+// its first factor-1 successors
+func (r *replicator) currentReplicas() []chord.NodeRef {
+	var replicas []chord.NodeRef
+	for _, succ := range r.localNode.GetSuccessorList() {
+		// in a ring smaller than the replication factor, the list wraps around to the local node
+		if len(replicas) == r.factor-1 || succ.GetID() == r.localNode.GetID() {
+			break
+		}
+		replicas = append(replicas, succ)
+	}
+	return replicas
+}
+
+// replicationFactor is the number of nodes storing the keys owned by the local node, counting it.
+// It's the replication factor unless the successor list wraps around to the local node, in a smaller ring.
+// A successor list cut short by an unreachable node, or reset to the successor, doesn't lower it
+func (r *replicator) replicationFactor() int {
+	for i, succ := range r.localNode.GetSuccessorList() {
+		if succ.GetID() == r.localNode.GetID() {
+			if i+1 < r.factor {
+				return i + 1
+			}
+			break
+		}
+	}
+	return r.factor
+}
+
+// markDirty makes the next re-replication copy all the owned keys, after a replica missed a write
+func (r *replicator) markDirty() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirty = true
+}
+
+// merge stores the copy of an item unless the stored one is newer
+func (r *replicator) merge(item storage.Item) error {
+	r.clock.observe(item.Version)
+	return r.store.Update(item.Key, func(current storage.Item, found bool) (storage.Item, bool) {
+		return item, !found || item.NewerThan(current)
+	})
+}
+
+// rereplicate copies all the owned keys to the replicas if they or the owned range changed
+// since the last time, or a replica missed a write. The keys of the range given up to a new
+// predecessor are handed over to it, and dropped unless the local node is one of their replicas.
+// What fails is retried by the next call
+func (r *replicator) rereplicate(ctx context.Context) (err error) {
+	pred, replicas := r.localNode.GetPredNode(), r.currentReplicas()
+	if pred == nil {
+		// the owned range is unknown until a predecessor notifies us
+		return nil
+	}
+
+	r.mu.Lock()
+	lastPred, lastReplicas, dirty := r.pred, r.replicas, r.dirty
+	r.dirty = false
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if err != nil {
+			r.dirty = r.dirty || dirty
+			return
+		}
+		r.pred, r.replicas = pred, replicas
+	}()
+
+	m, self := r.localNode.GetRank(), r.localNode.GetID()
+	if _, lost := chord.OwnershipChange(m, self, lastPred, pred); lost != nil {
+		items, err := r.itemsIn(*lost)
+		if err != nil {
+			return err
+		}
+		if err := r.pushItems(ctx, pred, items); err != nil {
+			return errors.Wrapf(err, "unable to hand %s over to %s", lost, pred)
+		}
+		// the replicas of the new owner are its next successors, the first of which is the local node
+		if r.factor < 2 {
+			if err := r.dropCopies(items); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !dirty && lastPred != nil && lastPred.GetID() == pred.GetID() && sameReplicas(lastReplicas, replicas) {
+		return nil
+	}
+	items, err := r.itemsIn(chord.NewInterval(m, pred.GetID(), self, chord.WithLeftOpen, chord.WithRightClosed))
+	if err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		if err := r.pushItems(ctx, replica, items); err != nil {
+			return errors.Wrapf(err, "unable to replicate to %s", replica)
+		}
+	}
+	logrus.Infof("replicated %d keys to %v", len(items), replicas)
+	return nil
+}
+
+// dropCopies removes the copies of the items handed over to another node, unless they changed since
+func (r *replicator) dropCopies(items []storage.Item) error {
+	for _, item := range items {
+		version := item.Version
+		if _, err := r.store.DeleteIf(item.Key, func(current storage.Item) bool {
+			return current.Version == version
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handOver copies the keys of the range to the node taking it over, when the local node leaves the ring
+func (r *replicator) handOver(ctx context.Context, iv chord.Interval, to chord.NodeRef) error {
+	items, err := r.itemsIn(iv)
+	if err != nil {
+		return err
+	}
+	if err := r.pushItems(ctx, to, items); err != nil {
+		return errors.Wrapf(err, "unable to hand %s over to %s", iv, to)
+	}
+	logrus.Infof("handed %d keys over to %s", len(items), to)
+	return nil
+}
+
+// itemsIn returns the stored items whose keys are in the range
+func (r *replicator) itemsIn(iv chord.Interval) ([]storage.Item, error) {
+	var items []storage.Item
+	m := r.localNode.GetRank()
+	err := r.store.ForEach(func(item storage.Item) bool {
+		if iv.Has(keyID(item.Key, m)) {
+			items = append(items, item)
+		}
+		return true
+	})
+	return items, err
+}
+
+func sameReplicas(a, b []chord.NodeRef) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].GetID() != b[i].GetID() {
+			return false
+		}
+	}
+	return true
+}
+
+func itemToProto(item storage.Item) *pb.KeyValue {
+	kv := &pb.KeyValue{Key: item.Key, Value: item.Value, Version: item.Version, Deleted: item.Deleted}
+	if !item.ExpiresAt.IsZero() {
+		kv.ExpiresAt = item.ExpiresAt.UnixNano()
+	}
+	return kv
+}
+
+func itemFromProto(kv *pb.KeyValue) storage.Item {
+	item := storage.Item{Key: kv.Key, Value: kv.Value, Version: kv.Version, Deleted: kv.Deleted}
+	if kv.ExpiresAt != 0 {
+		item.ExpiresAt = time.Unix(0, kv.ExpiresAt)
+	}
+	return item
+}
+
+// batch splits the items into batches of bounded size, and the items larger than a batch, which are streamed on their own
+func batch(items []storage.Item) (batches [][]*pb.KeyValue, large []storage.Item) {
+	var (
+		current []*pb.KeyValue
+		size    int
+	)
+	for _, item := range items {
+		if len(item.Value) > replicationBatchSize {
+			large = append(large, item)
+			continue
+		}
+		if size+len(item.Value) > replicationBatchSize && len(current) > 0 {
+			batches = append(batches, current)
+			current, size = nil, 0
+		}
+		current = append(current, itemToProto(item))
+		size += len(item.Key) + len(item.Value)
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches, large
+}
+
+// pushItems stores the items on the node as they are, in batches of bounded size.
+// An item larger than a batch is streamed on its own, in chunks
+func (r *replicator) pushItems(ctx context.Context, to chord.NodeRef, items []storage.Item) error {
+	batches, large := batch(items)
+	for _, item := range large {
+		if err := r.pushItem(ctx, to.GetBind(), item, nil); err != nil {
+			return err
+		}
+	}
+	for _, b := range batches {
+		req := &pb.ReplicateRequest{Items: b}
+		err := r.dialer.Call(ctx, to.GetBind(), "Replicate", func(ctx context.Context, client pb.ChordClient) error {
+			_, err := client.Replicate(ctx, req)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package chordio
+
+import (
+	"fmt"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// stabilizeRounds stabilizes every node in turn, the errors of the nodes catching up with a failure are ignored
+func stabilizeRounds(rounds int, nodes ...testNode) {
+	for i := 0; i < rounds; i++ {
+		for _, n := range nodes {
+			n.stabilize()
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	n0 := newReplicatedNode(0, 3, 2)
+	n3 := newReplicatedNode(3, 3, 2)
+	n5 := newReplicatedNode(5, 3, 2)
+	defer n0.stop()
+	defer n5.stop()
+
+	n3.join(n0)
+	n5.join(n0)
+	stabilizeRounds(4, n0, n3, n5)
+	n0.assertNeighbours(t, 5, 3)
+	n3.assertNeighbours(t, 0, 5)
+	n5.assertNeighbours(t, 3, 0)
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		assert.Nil(t, n3.put(keys[i], "value-"+keys[i], pb.Consistency_ALL))
+	}
+
+	t.Run("keys are stored on their owner and its successor", func(t *testing.T) {
+		for _, key := range keys {
+			var copies int
+			for _, n := range []testNode{n0, n3, n5} {
+				if n.stores(key) {
+					copies++
+				}
+			}
+			assert.Equal(t, 2, copies, key)
+		}
+	})
+
+	t.Run("no key is lost when a node fails", func(t *testing.T) {
+		n3.crash()
+		stabilizeRounds(3, n0, n5)
+		n0.assertNeighbours(t, 5, 5)
+		n5.assertNeighbours(t, 0, 0)
+
+		for _, key := range keys {
+			for _, n := range []testNode{n0, n5} {
+				resp, err := n.get(key, pb.Consistency_ONE)
+				if assert.Nil(t, err) {
+					assert.True(t, resp.Found, key)
+					assert.Equal(t, "value-"+key, string(resp.Value))
+				}
+			}
+			// the survivors are the replicas of each other
+			assert.True(t, n0.stores(key), key)
+			assert.True(t, n5.stores(key), key)
+		}
+	})
+
+	t.Run("a joining node takes over its keys", func(t *testing.T) {
+		n2 := newReplicatedNode(2, 3, 2)
+		defer n2.stop()
+
+		n2.join(n0)
+		stabilizeRounds(3, n0, n2, n5)
+		n2.assertNeighbours(t, 0, 5)
+
+		for _, key := range keys {
+			if id := keyID(key, 3); id == 1 || id == 2 {
+				assert.True(t, n2.stores(key), key)
+			}
+			resp, err := n2.get(key, pb.Consistency_ONE)
+			if assert.Nil(t, err) {
+				assert.True(t, resp.Found, key)
+				assert.Equal(t, "value-"+key, string(resp.Value))
+			}
+		}
+	})
+}
+
+func TestLeave(t *testing.T) {
+	n0 := newReplicatedNode(0, 3, 1)
+	n3 := newReplicatedNode(3, 3, 1)
+	n5 := newReplicatedNode(5, 3, 1)
+	defer n0.stop()
+	defer n5.stop()
+
+	n3.join(n0)
+	n5.join(n0)
+	stabilizeRounds(4, n0, n3, n5)
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		assert.Nil(t, n0.put(keys[i], "value-"+keys[i], pb.Consistency_ONE))
+	}
+	var owned int
+	for _, key := range keys {
+		if n3.stores(key) {
+			owned++
+		}
+	}
+	assert.NotZero(t, owned)
+
+	n3.stop()
+
+	t.Run("the neighbours of a node that left are linked to each other", func(t *testing.T) {
+		n0.assertNeighbours(t, 5, 5)
+		n5.assertNeighbours(t, 0, 0)
+	})
+
+	t.Run("the keys of a node that left are still readable", func(t *testing.T) {
+		for _, key := range keys {
+			for _, n := range []testNode{n0, n5} {
+				resp, err := n.get(key, pb.Consistency_ONE)
+				if assert.Nil(t, err) {
+					assert.True(t, resp.Found, key)
+					assert.Equal(t, "value-"+key, string(resp.Value))
+				}
+			}
+		}
+	})
+}
+
+func TestHandOverToJoiningNode(t *testing.T) {
+	n0 := newReplicatedNode(0, 3, 1)
+	n5 := newReplicatedNode(5, 3, 1)
+	defer n0.stop()
+	defer n5.stop()
+
+	n5.join(n0)
+	stabilizeRounds(4, n0, n5)
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		assert.Nil(t, n0.put(keys[i], "value-"+keys[i], pb.Consistency_ONE))
+	}
+
+	n2 := newReplicatedNode(2, 3, 1)
+	defer n2.stop()
+	n2.join(n0)
+	stabilizeRounds(3, n0, n2, n5)
+	n2.assertNeighbours(t, 0, 5)
+
+	var handedOver int
+	for _, key := range keys {
+		if id := keyID(key, 3); id == 1 || id == 2 {
+			handedOver++
+			assert.True(t, n2.stores(key), key)
+			// without replicas, the previous owner keeps no copy
+			assert.False(t, n5.stores(key), key)
+		}
+	}
+	assert.NotZero(t, handedOver)
+}
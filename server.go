@@ -6,17 +6,28 @@ import (
 	"github.com/kevinjqiu/chordio/chord"
 	"github.com/kevinjqiu/chordio/chord/node"
 	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
 	"github.com/kevinjqiu/chordio/telemetry"
+	"github.com/kevinjqiu/chordio/transport"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"go.opentelemetry.io/otel/api/global"
 	"go.opentelemetry.io/otel/plugin/grpctrace"
 	"google.golang.org/grpc"
-	"math/rand"
-	"net"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"sync"
 	"time"
 )
 
+const (
+	// seedInitialBackoff is the wait before trying the seeds again after none of them could be joined,
+	// doubled every time up to seedMaxBackoff
+	seedInitialBackoff = 100 * time.Millisecond
+	seedMaxBackoff     = 30 * time.Second
+)
+
 type PBNodeRef pb.Node
 
 func (p *PBNodeRef) GetID() chord.ID {
@@ -32,27 +43,54 @@ func (p *PBNodeRef) String() string {
 }
 
 type Server struct {
-	localNode           chord.LocalNode
-	grpcServer          *grpc.Server
+	// bind is the address the server listens on, the local node advertises its own address to peers
+	bind      string
+	localNode chord.LocalNode
+	// dialer connects to the peers with the credentials of the node
+	dialer     *node.Dialer
+	grpcServer *grpc.Server
+	httpServer *http.Server
+	readiness  *readiness
+	// drainPeriod is how long the node keeps serving once it reported it's leaving, before it leaves the ring
+	drainPeriod         time.Duration
+	seeds               []string
 	stabilizationConfig StabilizationConfig
+	fixFingersConfig    FixFingersConfig
+	reapPeriod          time.Duration
+	antiEntropyPeriod   time.Duration
+	// tombstoneGracePeriod is how long the tombstones of the deleted keys are kept
+	tombstoneGracePeriod time.Duration
+	store                storage.Engine
+	replicator           *replicator
+	// hints are kept for the unreachable replicas of other nodes
+	hints *hints
+	// uploads are the values being uploaded in chunks
+	uploads *uploads
+
+	// mu guards the lifecycle: cancel stops Run, done is closed once it returned.
+	// stopped is set by GracefulStop, so that a Run yet to start returns right away
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped bool
 }
 
 func (s *Server) X_Stabilize(ctx context.Context, _ *pb.StabilizeRequest) (*pb.StabilizeResponse, error) {
-	numChanges, err := s.localNode.Stabilize(ctx)
+	numChanges, err := s.stabilize(ctx)
 	return &pb.StabilizeResponse{
 		NumFingerTableEntryChanges: int32(numChanges),
 	}, err
 }
 
 func (s *Server) SetPredecessorNode(ctx context.Context, req *pb.SetPredecessorNodeRequest) (*pb.SetPredecessorNodeResponse, error) {
-	var nodeRef = PBNodeRef(*req.Node)
-	err := s.localNode.SetPredNode(ctx, &nodeRef)
+	var nodeRef = (*PBNodeRef)(req.Node)
+	err := s.localNode.SetPredNode(ctx, nodeRef)
 	return &pb.SetPredecessorNodeResponse{}, err
 }
 
 func (s *Server) SetSuccessorNode(ctx context.Context, req *pb.SetSuccessorNodeRequest) (*pb.SetSuccessorNodeResponse, error) {
-	var nodeRef = PBNodeRef(*req.Node)
-	err := s.localNode.SetSuccNode(ctx, &nodeRef)
+	var nodeRef = (*PBNodeRef)(req.Node)
+	err := s.localNode.SetSuccNode(ctx, nodeRef)
 	return &pb.SetSuccessorNodeResponse{}, err
 }
 
@@ -95,7 +133,7 @@ func (s *Server) FindSuccessor(ctx context.Context, request *pb.FindSuccessorReq
 
 	hops := request.Hops
 	hops = append(hops, &pb.Hop{
-		Id: s.localNode.GetID().AsU64(),
+		Id:   s.localNode.GetID().AsU64(),
 		Bind: s.localNode.GetBind(),
 	})
 
@@ -124,88 +162,534 @@ func (s *Server) ClosestPrecedingFinger(ctx context.Context, request *pb.Closest
 func (s *Server) JoinRing(ctx context.Context, request *pb.JoinRingRequest) (*pb.JoinRingResponse, error) {
 	logger := logrus.WithField("method", "Server.JoinRing")
 	logger.WithField("introducer", request.Introducer.String()).Info("join request")
-	introNode, err := node.NewRemote(ctx, request.Introducer.Bind)
+	introNode, err := s.dialer.NewRemote(ctx, request.Introducer.Bind)
 	if err != nil {
 		return nil, err
 	}
 	if err := s.localNode.Join(ctx, introNode); err != nil {
 		return nil, err
 	}
+	s.readiness.setJoined()
 	return &pb.JoinRingResponse{}, nil
 }
 
 func (s *Server) Notify(ctx context.Context, request *pb.NotifyRequest) (*pb.NotifyResponse, error) {
 	logger := logrus.WithField("method", "Server.Notify")
 	logger.Infof("node=%v", request.Node)
-	n, err := node.NewRemote(ctx, request.Node.Bind)
+	n, err := s.dialer.NewRemote(ctx, request.Node.Bind)
 	if err != nil {
 		return nil, err
 	}
 	if err := s.localNode.Notify(ctx, n); err != nil {
 		return nil, err
 	}
+	if n.GetID() != s.localNode.GetID() {
+		// another node has found us through the ring, so we're part of one even if we never joined
+		s.readiness.setJoined()
+	}
 	return &pb.NotifyResponse{}, nil
 }
 
-func (s *Server) runStabilizer(ticker *time.Ticker) {
+func (s *Server) WatchEvents(_ *pb.WatchEventsRequest, stream pb.Chord_WatchEventsServer) error {
+	logger := logrus.WithField("method", "Server.WatchEvents")
+	logger.Debug("watcher subscribed")
+
+	events, cancel := s.localNode.Subscribe()
+	defer cancel()
+
+	// the headers tell the watcher that it won't miss any event from now on
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
 	for {
 		select {
-		case <-ticker.C:
+		case e, ok := <-events:
+			if !ok {
+				// the node is leaving
+				return nil
+			}
+			if err := stream.Send(e.AsProtobufEvent()); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			logger.Debug("watcher unsubscribed")
+			return nil
+		}
+	}
+}
+
+// OnRangeAcquired registers f to be called with the key range the server's node takes over
+func (s *Server) OnRangeAcquired(f func(iv chord.Interval)) {
+	s.localNode.OnRangeAcquired(f)
+}
+
+// OnRangeReleased registers f to be called with the key range the server's node hands over
+func (s *Server) OnRangeReleased(f func(iv chord.Interval)) {
+	s.localNode.OnRangeReleased(f)
+}
+
+// joinSeeds joins the ring through the first seed that can be reached. The seeds are tried again
+// with an exponential backoff until one of them succeeds or ctx is done, they may not be started yet.
+// A node that is its only seed starts the ring
+func (s *Server) joinSeeds(ctx context.Context) error {
+	var seeds []string
+	for _, seed := range s.seeds {
+		if seed != s.localNode.GetBind() {
+			seeds = append(seeds, seed)
+		}
+	}
+	if len(seeds) == 0 {
+		return nil
+	}
+
+	backoff := seedInitialBackoff
+	for {
+		if s.joinAnySeed(ctx, seeds) {
+			return nil
+		}
+		logrus.Warnf("unable to join the ring through any of the seeds, trying again in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "unable to join the ring through any of the seeds")
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > seedMaxBackoff {
+			backoff = seedMaxBackoff
+		}
+	}
+}
+
+// joinAnySeed joins the ring through the first of the seeds that can be reached, returns whether one could
+func (s *Server) joinAnySeed(ctx context.Context, seeds []string) bool {
+	for _, seed := range seeds {
+		introNode, err := s.dialer.NewRemote(ctx, seed)
+		if err != nil {
+			logrus.Warnf("unable to reach seed %s: %s", seed, err)
+			continue
+		}
+		if err := s.localNode.Join(ctx, introNode); err != nil {
+			logrus.Warnf("unable to join through seed %s: %s", seed, err)
+			continue
+		}
+		logrus.Infof("joined the ring through seed %s", seed)
+		s.readiness.setJoined()
+		return true
+	}
+	return false
+}
+
+func (s *Server) stabilize(ctx context.Context) (int, error) {
+	numChanges, err := s.localNode.Stabilize(ctx)
+	if err != nil {
+		return numChanges, err
+	}
+	s.readiness.setStabilized()
+	// the keys follow the changes of the successors and the predecessor
+	if err := s.replicator.rereplicate(ctx); err != nil {
+		logrus.Error("re-replication failed: ", err)
+	}
+	// the replicas the hints are kept for may be back
+	numReplayed, err := s.hints.replay(ctx, s.replicator)
+	if err != nil {
+		logrus.Error("replaying the hints failed: ", err)
+	}
+	if numReplayed > 0 {
+		logrus.Infof("replayed %d hints", numReplayed)
+	}
+	return numChanges, nil
+}
+
+// runStabilizer stabilizes the node on the stabilization schedule until ctx is done.
+// A round in flight is allowed to finish, within the stabilization period
+func (s *Server) runStabilizer(ctx context.Context) {
+	schedule := newStabilizationSchedule(s.stabilizationConfig)
+	timer := time.NewTimer(schedule.next())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
 			logrus.Info("Run Stabilize()")
-			numChanges, err := s.localNode.Stabilize(context.Background())
+			// a round must not outlive the period, so that a hung peer can't hold stabilization back,
+			// and stops with the server
+			roundCtx, cancel := context.WithTimeout(ctx, s.stabilizationConfig.Period)
+			numChanges, err := s.stabilize(roundCtx)
+			cancel()
+			schedule.observe(numChanges, err)
+
+			next := schedule.next()
+			timer.Reset(next)
 			if err != nil {
 				logrus.Error("Stabilize failed", err)
 				continue
 			}
-			logrus.Infof("Number of finger table entries changed by stabilization: %d", numChanges)
+			logrus.Infof("Number of finger table entries changed by stabilization: %d, next round in %s", numChanges, next)
 		}
 	}
 }
 
-func (s *Server) Serve() error {
-	lis, err := net.Listen("tcp", s.localNode.GetBind())
+// runFingerFixer fixes the fingers every fix fingers period until ctx is done
+func (s *Server) runFingerFixer(ctx context.Context) {
+	ticker := time.NewTicker(s.fixFingersConfig.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, s.fixFingersConfig.Period)
+			numChanges, err := s.localNode.FixFingers(roundCtx)
+			cancel()
+			if err != nil {
+				logrus.Error("FixFingers failed", err)
+				continue
+			}
+			logrus.Infof("Number of finger table entries changed by fixing fingers: %d", numChanges)
+		}
+	}
+}
+
+// reap turns the expired values into tombstones, which keep their version until the tombstone grace period
+// passed so that an older copy held by a replica that missed the write with the TTL can't bring the key back,
+// and removes the tombstones past their grace period. Returns how many items it reaped
+func (s *Server) reap(now time.Time) (int, error) {
+	var expired []string
+	err := s.store.ForEach(func(item storage.Item) bool {
+		if item.Expired(now) {
+			expired = append(expired, item.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var numReaped int
+	for _, key := range expired {
+		var reaped bool
+		// the key may have been written again since
+		err := s.store.Update(key, func(item storage.Item, found bool) (storage.Item, bool) {
+			if !found || !item.Expired(now) || item.Deleted {
+				return item, false
+			}
+			// every replica turns the value into the same tombstone, as the expiry time is set by the owner
+			reaped = true
+			return storage.Item{
+				Key:       item.Key,
+				Version:   item.Version,
+				Deleted:   true,
+				ExpiresAt: item.ExpiresAt.Add(s.tombstoneGracePeriod),
+			}, true
+		})
+		if err != nil {
+			return numReaped, err
+		}
+		if !reaped {
+			reaped, err = s.store.DeleteIf(key, func(item storage.Item) bool {
+				return item.Deleted && item.Expired(now)
+			})
+			if err != nil {
+				return numReaped, err
+			}
+		}
+		if reaped {
+			numReaped++
+		}
+	}
+	return numReaped, nil
+}
+
+// runReaper removes the expired items every reap period until ctx is done
+func (s *Server) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(s.reapPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			numReaped, err := s.reap(now)
+			if err != nil {
+				logrus.Error("reaping failed: ", err)
+				continue
+			}
+			if numReaped > 0 {
+				logrus.Infof("reaped %d expired keys", numReaped)
+			}
+		}
+	}
+}
+
+// runUploadExpiry drops the uploads interrupted for too long until ctx is done, whether the reaper runs or not
+func (s *Server) runUploadExpiry(ctx context.Context) {
+	ticker := time.NewTicker(uploadExpiryPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if numExpired := s.uploads.expire(now.Add(-uploadTimeout)); numExpired > 0 {
+				logrus.Infof("dropped %d interrupted uploads", numExpired)
+			}
+		}
+	}
+}
+
+// runAntiEntropy repairs the replicas of the owned keys every anti-entropy period until ctx is done
+func (s *Server) runAntiEntropy(ctx context.Context) {
+	ticker := time.NewTicker(s.antiEntropyPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, s.antiEntropyPeriod)
+			err := s.replicator.antiEntropy(roundCtx)
+			cancel()
+			if err != nil {
+				logrus.Error("anti-entropy failed: ", err)
+			}
+		}
+	}
+}
+
+// Run serves the node until ctx is done or one of the servers fails. It runs the background loops,
+// joining the seeds, stabilization, fixing the fingers, reaping the expired keys, dropping the interrupted uploads and anti-entropy, and stops them before leaving the ring
+// and stopping the servers gracefully. Returns the first error of the servers, nil once stopped by ctx
+func (s *Server) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.done != nil {
+		s.mu.Unlock()
+		return errServerStarted
+	}
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.cancel, s.done = cancel, make(chan struct{})
+	defer close(s.done)
+	s.mu.Unlock()
+
+	lis, err := transport.Listen(s.bind)
 	if err != nil {
 		return err
 	}
 
-	pb.RegisterChordServer(s.grpcServer, s)
-	logrus.Info("serving chord grpc server at: ", s.localNode.GetBind())
+	logrus.Infof("serving chord grpc server at: %s, advertised as: %s", s.bind, s.localNode.GetBind())
 	logrus.Infof("nodeID: %d", s.localNode.GetID())
 
+	var (
+		loops, servers sync.WaitGroup
+		errs           = make(chan error, 2)
+	)
+	// serve runs a server, a failure stops the others
+	serve := func(f func() error) {
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := f(); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+	loop := func(f func()) {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			f()
+		}()
+	}
+
+	serve(func() error {
+		return s.grpcServer.Serve(lis)
+	})
+	if s.httpServer != nil {
+		serve(s.serveHealthHTTP)
+	}
+
+	if len(s.seeds) > 0 {
+		loop(func() {
+			if err := s.joinSeeds(ctx); err != nil {
+				logrus.Error(err)
+			}
+		})
+	}
+
 	if !s.stabilizationConfig.Disabled {
-		rand.Seed(time.Now().UnixNano())
-		jitter := time.Duration(rand.Int63() % s.stabilizationConfig.Jitter.Nanoseconds())
-		runInterval := jitter + s.stabilizationConfig.Period
-		logrus.Infof("jitter: %s, interval: %s", jitter, runInterval)
-		tickerStabilize := time.NewTicker(runInterval)
-		go s.runStabilizer(tickerStabilize)
+		loop(func() {
+			s.runStabilizer(ctx)
+		})
+
+		if s.fixFingersConfig.Period > 0 {
+			loop(func() {
+				s.runFingerFixer(ctx)
+			})
+		}
+	}
+
+	if s.reapPeriod > 0 {
+		loop(func() {
+			s.runReaper(ctx)
+		})
 	}
 
-	return s.grpcServer.Serve(lis)
+	if s.antiEntropyPeriod > 0 {
+		loop(func() {
+			s.runAntiEntropy(ctx)
+		})
+	}
+
+	loop(func() {
+		s.runUploadExpiry(ctx)
+	})
+
+	<-ctx.Done()
+	loops.Wait()
+	s.stop()
+	servers.Wait()
+
+	close(errs)
+	return <-errs
 }
 
+// Serve runs the server until GracefulStop is called
+func (s *Server) Serve() error {
+	return s.Run(context.Background())
+}
+
+// GracefulStop stops the server started by Serve or Run, and waits for it to be stopped.
+// A server that isn't started yet won't start
 func (s *Server) GracefulStop() {
+	s.mu.Lock()
+	s.stopped = true
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// stop leaves the ring and stops the servers once the background loops are done.
+// The node reports it's leaving for the drain period first, for the clients to stop sending it requests
+func (s *Server) stop() {
 	logrus.Infof("Stopping server: %s", s.localNode.String())
+	s.readiness.setLeaving()
+	if s.drainPeriod > 0 {
+		logrus.Infof("draining for %s before leaving the ring", s.drainPeriod)
+		time.Sleep(s.drainPeriod)
+	}
+	if err := s.localNode.Leave(context.Background()); err != nil {
+		logrus.Error("unable to leave the ring: ", err)
+	}
+	if s.httpServer != nil {
+		if err := s.httpServer.Shutdown(context.Background()); err != nil {
+			logrus.Error("unable to stop the health endpoint: ", err)
+		}
+	}
 	s.grpcServer.GracefulStop()
+	s.uploads.close()
 }
 
 func NewServer(config Config) (*Server, error) {
 	var err error
 
-	localNode, err := node.NewLocal(config.ID, config.Bind, config.M)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	advertise, err := config.AdvertiseAddr()
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to initiate local node")
+		return nil, err
 	}
 
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(grpctrace.UnaryServerInterceptor(global.Tracer(telemetry.GetServiceName()))),
-		grpc.StreamInterceptor(grpctrace.StreamServerInterceptor(global.Tracer(telemetry.GetServiceName()))),
+	fixFingersPolicy, err := node.ParseFixFingersPolicy(config.FixFingers.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	serverOptions := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpctrace.UnaryServerInterceptor(telemetry.Tracer())),
+		grpc.StreamInterceptor(grpctrace.StreamServerInterceptor(telemetry.Tracer())),
+	}
+	// the peers are reached in plaintext unless TLS is enabled
+	var clientCreds credentials.TransportCredentials
+	if config.TLS.Enabled() {
+		serverCreds, err := config.TLS.ServerCredentials()
+		if err != nil {
+			return nil, err
+		}
+		clientCreds, err = config.TLS.ClientCredentials()
+		if err != nil {
+			return nil, err
+		}
+		serverOptions = append(serverOptions, grpc.Creds(serverCreds))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	dialer := node.NewDialer(clientCreds, config.RPC.policy())
+
+	localNode, err := node.NewLocal(config.ID, advertise, config.M,
+		node.WithProximityCandidates(config.Proximity.Candidates),
+		node.WithFixFingersPolicy(fixFingersPolicy),
+		node.WithFixFingersWorkers(config.FixFingers.Workers),
+		// the replicas are the next successors, which the node fails over to
+		node.WithSuccessorListLength(config.Replication.factor()),
+		// with a period of their own, the fingers aren't fixed by stabilization anymore
+		node.WithFixFingersOnStabilize(config.FixFingers.Period == 0),
+		node.WithDialer(dialer),
 	)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to initiate local node")
+	}
 
+	store, err := config.Storage.newEngine()
+	if err != nil {
+		return nil, err
+	}
 	s := Server{
-		localNode:           localNode,
-		grpcServer:          grpcServer,
-		stabilizationConfig: config.Stabilization,
+		bind:                 config.Bind,
+		localNode:            localNode,
+		dialer:               dialer,
+		grpcServer:           grpcServer,
+		readiness:            newReadiness(),
+		drainPeriod:          config.Health.DrainPeriod,
+		seeds:                config.Seeds,
+		stabilizationConfig:  config.Stabilization,
+		fixFingersConfig:     config.FixFingers,
+		reapPeriod:           config.Storage.ReapPeriod,
+		antiEntropyPeriod:    config.AntiEntropy.Period,
+		tombstoneGracePeriod: config.Storage.tombstoneGracePeriod(),
+		store:                store,
+		replicator:           newReplicator(localNode, dialer, store, config.Replication.factor()),
+		hints:                newHints(config.Replication.maxHints()),
+		uploads:              newUploads(config.Storage.UploadDir, config.Storage.maxValueSize(), config.Storage.maxPendingUploads()),
+	}
+
+	// the keys owned when the node leaves are handed over to its successor
+	localNode.OnHandOver(s.replicator.handOver)
+
+	pb.RegisterChordServer(grpcServer, &s)
+	healthpb.RegisterHealthServer(grpcServer, s.readiness.healthServer)
+
+	if config.Health.Bind != "" {
+		s.httpServer = &http.Server{
+			Addr:    config.Health.Bind,
+			Handler: newHealthHTTPHandler(s.readiness),
+		}
 	}
 	return &s, nil
 }
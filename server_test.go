@@ -1,9 +1,23 @@
 package chordio
 
 import (
+	"context"
 	"fmt"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/transport"
+	"github.com/phayes/freeport"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func withCluster(m int, nodeIDs []int, f func(nodes map[int]testNode)) {
@@ -16,10 +30,10 @@ func withCluster(m int, nodeIDs []int, f func(nodes map[int]testNode)) {
 	wg := sync.WaitGroup{}
 	wg.Add(len(testNodes))
 	for _, tn := range testNodes {
-		go func() {
+		go func(tn testNode) {
 			tn.status()
 			wg.Done()
-		}()
+		}(tn)
 	}
 	wg.Wait()
 
@@ -84,6 +98,223 @@ func TestServer(t *testing.T) {
 		}
 	})
 
+	t.Run("nodes are not serving until they joined a ring and stabilized", func(t *testing.T) {
+		withCluster(3, []int{0, 1}, func(nodes map[int]testNode) {
+			assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, nodes[0].health())
+			assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, nodes[1].health())
+
+			// alone in its ring, a node started without seeds is never ready
+			nodes[0].stabilize()
+			nodes[0].stabilize()
+			assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, nodes[0].health())
+
+			nodes[0].join(nodes[1])
+			assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, nodes[0].health())
+
+			nodes[0].stabilize()
+			nodes[1].stabilize()
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, nodes[0].health())
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, nodes[1].health())
+
+			nodes[0].s.readiness.setLeaving()
+			assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, nodes[0].health())
+		})
+	})
+
+	t.Run("a node started before its seed joins it once it's up", func(t *testing.T) {
+		seedAddr := inprocAddr(1)
+		n0 := newNodeWithConfig(Config{
+			ID:    0,
+			M:     3,
+			Bind:  inprocAddr(0),
+			Seeds: []string{seedAddr},
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+		})
+		defer n0.stop()
+		// the seed can't be reached while the node is serving
+		n0.health()
+
+		seed := newNodeAt(1, 3, seedAddr)
+		defer seed.stop()
+		assert.Eventually(t, func() bool {
+			return n0.status().Node.GetSucc().GetId() == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("a stopping node reports it's not serving for the drain period before it leaves", func(t *testing.T) {
+		n := newNodeWithConfig(Config{
+			ID:   0,
+			M:    3,
+			Bind: inprocAddr(0),
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+			Health: HealthConfig{
+				DrainPeriod: time.Second,
+			},
+		})
+		n.s.readiness.setJoined()
+		n.s.readiness.setStabilized()
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, n.health())
+
+		start := time.Now()
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			n.stop()
+		}()
+		// the health is still served while draining
+		assert.Eventually(t, func() bool {
+			return n.health() == healthpb.HealthCheckResponse_NOT_SERVING
+		}, time.Second, 10*time.Millisecond)
+		<-stopped
+		assert.True(t, time.Since(start) >= time.Second)
+	})
+
+	t.Run("watchers see the node converge after joining", func(t *testing.T) {
+		withCluster(3, []int{0, 1}, func(nodes map[int]testNode) {
+			events, cancel := nodes[0].watch()
+			defer cancel()
+
+			nodes[0].join(nodes[1])
+			nodes[0].stabilize()
+			nodes[1].stabilize()
+
+			var seen []string
+			for e := range events {
+				seen = append(seen, e.Type.String())
+				if e.Type == pb.EventType_RANGE_ACQUIRED {
+					assert.Equal(t, uint64(1), e.Range.GetStart())
+					assert.Equal(t, uint64(0), e.Range.GetEnd())
+					break
+				}
+			}
+			assert.Equal(t, []string{
+				"PREDECESSOR_CHANGED", "RANGE_LOST", "SUCCESSOR_CHANGED", "JOINED",
+				"FINGER_UPDATED", "FINGER_UPDATED", "STABILIZED",
+				"PREDECESSOR_CHANGED", "RANGE_ACQUIRED",
+			}, seen)
+		})
+	})
+
+	t.Run("ownership hooks follow the predecessor changes", func(t *testing.T) {
+		withCluster(3, []int{0, 1}, func(nodes map[int]testNode) {
+			var (
+				mu      sync.Mutex
+				changes []string
+			)
+			record := func(id int, change string) func(iv chord.Interval) {
+				return func(iv chord.Interval) {
+					mu.Lock()
+					defer mu.Unlock()
+					changes = append(changes, fmt.Sprintf("%d %s %s", id, change, iv))
+				}
+			}
+			for id, n := range nodes {
+				n.s.OnRangeAcquired(record(id, "acquired"))
+				n.s.OnRangeReleased(record(id, "released"))
+			}
+
+			nodes[0].join(nodes[1])
+			nodes[0].stabilize()
+			nodes[1].stabilize()
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, []string{
+				"0 released (0, 0]",
+				"1 released (1, 0]",
+				"0 acquired (1, 0]",
+			}, changes)
+		})
+	})
+
+	t.Run("nodes join each other across unix domain sockets and TCP", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "chordio-sockets")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		port, err := freeport.GetFreePort()
+		assert.Nil(t, err)
+
+		nodes := map[int]testNode{
+			0: newNodeAt(0, 3, "unix://"+filepath.Join(dir, "node-0.sock")),
+			1: newNodeAt(1, 3, fmt.Sprintf("127.0.0.1:%d", port)),
+		}
+		defer nodes[0].stop()
+		defer nodes[1].stop()
+
+		nodes[0].join(nodes[1])
+		nodes[0].stabilize()
+		nodes[1].stabilize()
+
+		nodes[0].assertNeighbours(t, 1, 1)
+		nodes[1].assertNeighbours(t, 0, 0)
+	})
+
+	t.Run("the TLS credentials of a node don't change how the other nodes dial their peers", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "chordio-tls")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		certFile, keyFile := writeTestCertificate(t, dir)
+		_, err = NewServer(Config{
+			ID:   2,
+			M:    3,
+			Bind: inprocAddr(2),
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+			TLS: TLSConfig{CertFile: certFile, KeyFile: keyFile},
+		})
+		assert.Nil(t, err)
+
+		nodes := map[int]testNode{
+			0: newNode(0, 3),
+			1: newNode(1, 3),
+		}
+		defer nodes[0].stop()
+		defer nodes[1].stop()
+
+		nodes[0].join(nodes[1])
+		nodes[0].stabilize()
+		nodes[1].stabilize()
+
+		nodes[0].assertNeighbours(t, 1, 1)
+		nodes[1].assertNeighbours(t, 0, 0)
+	})
+
+	t.Run("lookups are served while the ring stabilizes", func(t *testing.T) {
+		withCluster(3, []int{0, 1, 3}, func(nodes map[int]testNode) {
+			nodes[0].join(nodes[1])
+			nodes[3].join(nodes[0])
+
+			var wg sync.WaitGroup
+			for _, n := range nodes {
+				wg.Add(2)
+				go func(n testNode) {
+					defer wg.Done()
+					for i := 0; i < 5; i++ {
+						n.stabilize()
+					}
+				}(n)
+				go func(n testNode) {
+					defer wg.Done()
+					c, close := n.getClient()
+					defer close()
+					for i := 0; i < 20; i++ {
+						_, err := c.FindSuccessor(context.Background(), &pb.FindSuccessorRequest{Id: uint64(i % 8)})
+						assert.Nil(t, err)
+						n.status()
+					}
+				}(n)
+			}
+			wg.Wait()
+		})
+	})
+
 	t.Run("after n3 join n1", func(t *testing.T) {
 		withCluster(3, []int{0, 1, 3}, func(nodes map[int]testNode) {
 			nodes[0].join(nodes[1])
@@ -107,3 +338,106 @@ func TestServer(t *testing.T) {
 		})
 	})
 }
+
+func TestServerLifecycle(t *testing.T) {
+	newServer := func(t *testing.T, health string) *Server {
+		s, err := NewServer(Config{
+			ID:   1,
+			M:    3,
+			Bind: fmt.Sprintf("inproc://lifecycle-%d", atomic.AddInt64(&numTestNodes, 1)),
+			Stabilization: StabilizationConfig{
+				Period: 10 * time.Millisecond,
+				Jitter: 10 * time.Millisecond,
+			},
+			FixFingers: FixFingersConfig{Period: 10 * time.Millisecond},
+			Health:     HealthConfig{Bind: health},
+		})
+		assert.Nil(t, err)
+		return s
+	}
+
+	t.Run("Run stops the background loops once the context is cancelled", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		s := newServer(t, "")
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error)
+		go func() {
+			errs <- s.Run(ctx)
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-errs:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run didn't return")
+		}
+
+		// the servers may take a moment to release their goroutines
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.True(t, runtime.NumGoroutine() <= before, "leaked %d goroutines", runtime.NumGoroutine()-before)
+
+		assert.Equal(t, errServerStarted, s.Run(context.Background()))
+	})
+
+	t.Run("Run returns the error of a failed server", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer lis.Close()
+
+		s := newServer(t, lis.Addr().String())
+		errs := make(chan error)
+		go func() {
+			errs <- s.Run(context.Background())
+		}()
+
+		select {
+		case err := <-errs:
+			assert.NotNil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run didn't return")
+		}
+	})
+
+	t.Run("GracefulStop waits for Serve to return", func(t *testing.T) {
+		s := newServer(t, "")
+		returned := make(chan struct{})
+		go func() {
+			s.Serve()
+			close(returned)
+		}()
+		// the health check returns once the server is serving
+		testNode{addr: s.bind}.health()
+
+		s.GracefulStop()
+		// the servers are stopped by the time it returns
+		_, err := transport.Dial(context.Background(), s.bind)
+		assert.NotNil(t, err)
+		select {
+		case <-returned:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Serve didn't return")
+		}
+	})
+
+	t.Run("GracefulStop before Serve started stops it", func(t *testing.T) {
+		s := newServer(t, "")
+		s.GracefulStop()
+
+		returned := make(chan error)
+		go func() {
+			returned <- s.Serve()
+		}()
+		select {
+		case err := <-returned:
+			assert.Nil(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Serve didn't return")
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package chordio
+
+import (
+	"math/rand"
+	"time"
+)
+
+// stabilizationSchedule decides when the next stabilization round runs
+type stabilizationSchedule struct {
+	config StabilizationConfig
+	period time.Duration
+	rand   *rand.Rand
+}
+
+func newStabilizationSchedule(config StabilizationConfig) *stabilizationSchedule {
+	return &stabilizationSchedule{
+		config: config,
+		period: config.Period,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the wait before the next round, the current period plus a jitter drawn for every round
+// so that the nodes restarted together don't keep stabilizing at the same time
+func (s *stabilizationSchedule) next() time.Duration {
+	if s.config.Jitter <= 0 {
+		return s.period
+	}
+	return s.period + time.Duration(s.rand.Int63n(int64(s.config.Jitter)))
+}
+
+// observe the outcome of a round: with an adaptive period, a round which changed the finger table
+// halves the period and a round which didn't doubles it, within the bounds of the config.
+// A failed round leaves the period as it is
+func (s *stabilizationSchedule) observe(numChanges int, err error) {
+	if !s.config.Adaptive || err != nil {
+		return
+	}
+	if numChanges > 0 {
+		s.period /= 2
+		if min := s.config.minPeriod(); s.period < min {
+			s.period = min
+		}
+	} else {
+		s.period *= 2
+		if max := s.config.maxPeriod(); s.period > max {
+			s.period = max
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package chordio
+
+import (
+	"errors"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStabilizationSchedule(t *testing.T) {
+	t.Run("no jitter", func(t *testing.T) {
+		s := newStabilizationSchedule(StabilizationConfig{Period: time.Second})
+		assert.Equal(t, time.Second, s.next())
+		assert.Equal(t, time.Second, s.next())
+	})
+
+	t.Run("the jitter is drawn for every round", func(t *testing.T) {
+		s := newStabilizationSchedule(StabilizationConfig{Period: time.Second, Jitter: time.Second})
+		waits := map[time.Duration]bool{}
+		for i := 0; i < 10; i++ {
+			wait := s.next()
+			assert.True(t, wait >= time.Second && wait < 2*time.Second)
+			waits[wait] = true
+		}
+		assert.True(t, len(waits) > 1)
+	})
+
+	t.Run("the period is fixed unless adaptive", func(t *testing.T) {
+		s := newStabilizationSchedule(StabilizationConfig{Period: time.Second})
+		s.observe(3, nil)
+		assert.Equal(t, time.Second, s.next())
+	})
+
+	t.Run("the adaptive period follows the changes within its bounds", func(t *testing.T) {
+		s := newStabilizationSchedule(StabilizationConfig{Period: 4 * time.Second, Adaptive: true, MaxPeriod: 10 * time.Second})
+
+		s.observe(2, nil)
+		assert.Equal(t, 2*time.Second, s.next())
+		s.observe(1, nil)
+		assert.Equal(t, time.Second, s.next())
+		s.observe(1, nil)
+		assert.Equal(t, time.Second, s.next(), "bounded by period/4")
+
+		s.observe(0, errors.New("unreachable successor"))
+		assert.Equal(t, time.Second, s.next(), "failed rounds don't count")
+
+		s.observe(0, nil)
+		s.observe(0, nil)
+		s.observe(0, nil)
+		s.observe(0, nil)
+		assert.Equal(t, 10*time.Second, s.next(), "bounded by maxPeriod")
+	})
+}
+
+func TestStabilizeCountsNeighbourChanges(t *testing.T) {
+	newNode := func(id int) testNode {
+		return newNodeWithConfig(Config{
+			ID:   chord.ID(id),
+			M:    3,
+			Bind: inprocAddr(id),
+			Stabilization: StabilizationConfig{
+				Disabled: true,
+			},
+			// the fingers aren't fixed by stabilization, only the neighbours count
+			FixFingers: FixFingersConfig{Period: time.Hour},
+		})
+	}
+	n0, n4 := newNode(0), newNode(4)
+	defer n0.stop()
+	defer n4.stop()
+
+	n4.join(n0)
+	numChanges, err := n4.stabilize()
+	assert.Nil(t, err)
+	assert.True(t, numChanges > 0, "the successor and predecessor of n4 changed")
+	numChanges, err = n0.stabilize()
+	assert.Nil(t, err)
+	assert.True(t, numChanges > 0, "the successor and predecessor of n0 changed")
+
+	stabilizeRounds(3, n0, n4)
+	for _, n := range []testNode{n0, n4} {
+		numChanges, err := n.stabilize()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, numChanges)
+	}
+}
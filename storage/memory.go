@@ -0,0 +1,80 @@
+package storage
+
+import "sync"
+
+// memory keeps the items in memory, they're lost when the node stops
+type memory struct {
+	mu    sync.RWMutex
+	items map[string]Item
+}
+
+func (m *memory) Get(key string) (Item, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.items[key]
+	return item, ok, nil
+}
+
+func (m *memory) Put(item Item) error {
+	// the caller may reuse the buffer of the value
+	item.Value = append([]byte(nil), item.Value...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[item.Key] = item
+	return nil
+}
+
+func (m *memory) Update(key string, f func(current Item, found bool) (Item, bool)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, found := m.items[key]
+	item, ok := f(current, found)
+	if !ok {
+		return nil
+	}
+	item.Key = key
+	item.Value = append([]byte(nil), item.Value...)
+	m.items[key] = item
+	return nil
+}
+
+func (m *memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+func (m *memory) DeleteIf(key string, f func(current Item) bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.items[key]
+	if !ok || !f(current) {
+		return false, nil
+	}
+	delete(m.items, key)
+	return true, nil
+}
+
+func (m *memory) ForEach(f func(item Item) bool) error {
+	// f may call back into the engine, so it runs on a copy
+	m.mu.RLock()
+	items := make([]Item, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	m.mu.RUnlock()
+
+	for _, item := range items {
+		if !f(item) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// NewMemory returns an engine keeping the items in memory
+func NewMemory() Engine {
+	return &memory{items: make(map[string]Item)}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	t.Run("items are stored by key", func(t *testing.T) {
+		m := NewMemory()
+		assert.Nil(t, m.Put(Item{Key: "a", Value: []byte("1")}))
+		assert.Nil(t, m.Put(Item{Key: "a", Value: []byte("2")}))
+
+		item, ok, err := m.Get("a")
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("2"), item.Value)
+
+		assert.Nil(t, m.Delete("a"))
+		_, ok, err = m.Get("a")
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("stored values don't share the buffer of the caller", func(t *testing.T) {
+		m := NewMemory()
+		value := []byte("1")
+		assert.Nil(t, m.Put(Item{Key: "a", Value: value}))
+		value[0] = '2'
+
+		item, _, _ := m.Get("a")
+		assert.Equal(t, []byte("1"), item.Value)
+	})
+
+	t.Run("updates see the current item", func(t *testing.T) {
+		m := NewMemory()
+		increment := func(current Item, found bool) (Item, bool) {
+			return Item{Value: []byte("v"), Version: current.Version + 1}, true
+		}
+		assert.Nil(t, m.Update("a", increment))
+		assert.Nil(t, m.Update("a", increment))
+		assert.Nil(t, m.Update("a", func(current Item, found bool) (Item, bool) {
+			return Item{Version: 100}, false
+		}))
+
+		item, ok, _ := m.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, Item{Key: "a", Value: []byte("v"), Version: 2}, item)
+	})
+
+	t.Run("items are deleted if the condition holds", func(t *testing.T) {
+		m := NewMemory()
+		assert.Nil(t, m.Put(Item{Key: "a", Version: 2}))
+		olderThan := func(version uint64) func(Item) bool {
+			return func(current Item) bool {
+				return current.Version < version
+			}
+		}
+
+		deleted, err := m.DeleteIf("a", olderThan(2))
+		assert.Nil(t, err)
+		assert.False(t, deleted)
+		_, ok, _ := m.Get("a")
+		assert.True(t, ok)
+
+		deleted, err = m.DeleteIf("a", olderThan(3))
+		assert.Nil(t, err)
+		assert.True(t, deleted)
+		_, ok, _ = m.Get("a")
+		assert.False(t, ok)
+	})
+
+	t.Run("every item is visited until the callback stops", func(t *testing.T) {
+		m := NewMemory()
+		for _, key := range []string{"a", "b", "c"} {
+			assert.Nil(t, m.Put(Item{Key: key}))
+		}
+
+		var keys []string
+		assert.Nil(t, m.ForEach(func(item Item) bool {
+			keys = append(keys, item.Key)
+			return true
+		}))
+		sort.Strings(keys)
+		assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+		var visited int
+		assert.Nil(t, m.ForEach(func(item Item) bool {
+			visited++
+			return false
+		}))
+		assert.Equal(t, 1, visited)
+	})
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+)
+
+// Item is a stored key and its value
+type Item struct {
+	Key   string
+	Value []byte
+	// Version orders the writes of the key, the newest one wins
+	Version uint64
+	// ExpiresAt is when the item expires, never if zero
+	ExpiresAt time.Time
+	// Deleted marks a tombstone, which supersedes the older values of the key until it expires
+	Deleted bool
+}
+
+// Expired reports whether the item has expired at now
+func (i Item) Expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
+}
+
+// Live reports whether the item holds a value at now, it's neither a tombstone nor expired
+func (i Item) Live(now time.Time) bool {
+	return !i.Deleted && !i.Expired(now)
+}
+
+// NewerThan reports whether the item supersedes other. Items of the same version are ordered
+// by value, tombstones first, so that the replicas agree on the winner
+func (i Item) NewerThan(other Item) bool {
+	if i.Version != other.Version {
+		return i.Version > other.Version
+	}
+	if i.Deleted != other.Deleted {
+		return i.Deleted
+	}
+	return bytes.Compare(i.Value, other.Value) > 0
+}
+
+// Engine stores the items of a node, it must be safe for concurrent use
+type Engine interface {
+	// Get returns the item of the key, false if there's none
+	Get(key string) (Item, bool, error)
+	// Put stores the item, replacing the item of the same key
+	Put(item Item) error
+	// Update atomically replaces the item of the key by the one returned by f if it returns true.
+	// f is called with the current item of the key, if found, and must not call the engine
+	Update(key string, f func(current Item, found bool) (Item, bool)) error
+	// Delete removes the item of the key, if any
+	Delete(key string) error
+	// DeleteIf atomically removes the item of the key if f returns true for it, returns whether it did.
+	// f must not call the engine
+	DeleteIf(key string, f func(current Item) bool) (bool, error)
+	// ForEach calls f with every item, in no particular order, until it returns false
+	ForEach(f func(item Item) bool) error
+}
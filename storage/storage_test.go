@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestItem(t *testing.T) {
+	t.Run("the newer version wins", func(t *testing.T) {
+		assert.True(t, Item{Value: []byte("a"), Version: 2}.NewerThan(Item{Value: []byte("b"), Version: 1}))
+		assert.False(t, Item{Value: []byte("b"), Version: 1}.NewerThan(Item{Value: []byte("a"), Version: 2}))
+	})
+
+	t.Run("the value breaks the ties", func(t *testing.T) {
+		assert.True(t, Item{Value: []byte("b"), Version: 1}.NewerThan(Item{Value: []byte("a"), Version: 1}))
+		assert.False(t, Item{Value: []byte("a"), Version: 1}.NewerThan(Item{Value: []byte("a"), Version: 1}))
+	})
+
+	t.Run("a tombstone wins over a value of the same version", func(t *testing.T) {
+		assert.True(t, Item{Deleted: true, Version: 1}.NewerThan(Item{Value: []byte("a"), Version: 1}))
+		assert.False(t, Item{Value: []byte("a"), Version: 1}.NewerThan(Item{Deleted: true, Version: 1}))
+		assert.True(t, Item{Value: []byte("a"), Version: 2}.NewerThan(Item{Deleted: true, Version: 1}))
+	})
+}
+
+func TestItemExpiry(t *testing.T) {
+	now := time.Now()
+	assert.False(t, Item{}.Expired(now))
+	assert.False(t, Item{ExpiresAt: now.Add(time.Second)}.Expired(now))
+	assert.True(t, Item{ExpiresAt: now}.Expired(now))
+
+	assert.True(t, Item{}.Live(now))
+	assert.False(t, Item{Deleted: true}.Live(now))
+	assert.False(t, Item{ExpiresAt: now}.Live(now))
+}
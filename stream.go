@@ -0,0 +1,532 @@
+package chordio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/kevinjqiu/chordio/chord"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// chunkSize is the size of the chunks of the values streamed to the peers and the clients
+	chunkSize = 1 << 20
+	// uploadTimeout is how long an interrupted upload can be resumed
+	uploadTimeout = time.Hour
+	// uploadExpiryPeriod is the period of dropping the uploads interrupted for longer than uploadTimeout
+	uploadExpiryPeriod = time.Minute
+)
+
+var (
+	errEmptyUploadID = status.Error(codes.InvalidArgument, "the upload ID must not be empty")
+	errStreamClosed  = status.Error(codes.Unavailable, "the stream was closed by the server")
+)
+
+// sendError is the error of a failed send on a client stream. The tracing interceptor of the clients
+// can't be used once a send failed, so the status of the stream is lost
+func sendError(err error) error {
+	if err == io.EOF {
+		return errStreamClosed
+	}
+	return err
+}
+
+// upload is a value being uploaded by PutStream, it can be resumed from its size.
+// The data received so far is spooled to a file rather than kept in memory
+type upload struct {
+	key     string
+	file    *os.File
+	size    int64
+	hash    hash.Hash
+	updated time.Time
+	// busy is set while a stream writes to the upload
+	busy bool
+}
+
+// write appends data to the upload, unless it would grow past maxSize
+func (up *upload) write(data []byte, maxSize int64) error {
+	if up.size+int64(len(data)) > maxSize {
+		return status.Errorf(codes.ResourceExhausted, "the value of %q is larger than %d bytes", up.key, maxSize)
+	}
+	if _, err := up.file.Write(data); err != nil {
+		return err
+	}
+	up.hash.Write(data)
+	up.size += int64(len(data))
+	return nil
+}
+
+// value reads the data uploaded
+func (up *upload) value() ([]byte, error) {
+	return ioutil.ReadFile(up.file.Name())
+}
+
+// discard removes the file the upload is spooled to
+func (up *upload) discard() {
+	up.file.Close()
+	if err := os.Remove(up.file.Name()); err != nil {
+		logrus.Warnf("unable to remove the upload of %q: %s", up.key, err)
+	}
+}
+
+// uploads are the uploads of the keys owned by the local node, by ID
+type uploads struct {
+	mu   sync.Mutex
+	byID map[string]*upload
+	// dir is where the uploads are spooled, the temporary directory if empty
+	dir string
+	// maxSize bounds the size of every upload, maxPending the number of uploads
+	maxSize    int64
+	maxPending int
+}
+
+func newUploads(dir string, maxSize int64, maxPending int) *uploads {
+	return &uploads{byID: make(map[string]*upload), dir: dir, maxSize: maxSize, maxPending: maxPending}
+}
+
+// acquire returns the upload of the ID for a stream to write to it from the offset,
+// which must be where it stopped, 0 for a new upload. It's released by release
+func (u *uploads) acquire(key, id string, offset uint64) (*upload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	up, ok := u.byID[id]
+	if !ok {
+		if len(u.byID) >= u.maxPending {
+			return nil, status.Errorf(codes.ResourceExhausted, "there are already %d uploads pending", len(u.byID))
+		}
+		file, err := ioutil.TempFile(u.dir, "upload-")
+		if err != nil {
+			return nil, err
+		}
+		up = &upload{key: key, file: file, hash: sha256.New()}
+		u.byID[id] = up
+	}
+	if up.key != key {
+		return nil, status.Errorf(codes.InvalidArgument, "upload %q is for key %q", id, up.key)
+	}
+	if up.busy {
+		return nil, status.Errorf(codes.Aborted, "upload %q is in progress", id)
+	}
+	if size := uint64(up.size); offset != size {
+		return nil, status.Errorf(codes.FailedPrecondition, "upload %q resumes from offset %d, got %d", id, size, offset)
+	}
+	up.busy, up.updated = true, time.Now()
+	return up, nil
+}
+
+// release ends the stream writing to the upload, which is forgotten if it's done
+func (u *uploads) release(id string, up *upload, done bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up.busy, up.updated = false, time.Now()
+	if done {
+		delete(u.byID, id)
+		up.discard()
+	}
+}
+
+// offset is where the upload of the ID resumes from, 0 if there's none
+func (u *uploads) offset(id string) uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, ok := u.byID[id]
+	if !ok || up.busy {
+		return 0
+	}
+	return uint64(up.size)
+}
+
+// expire forgets the uploads interrupted before the given time, returns how many
+func (u *uploads) expire(before time.Time) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var numExpired int
+	for id, up := range u.byID {
+		if !up.busy && up.updated.Before(before) {
+			delete(u.byID, id)
+			up.discard()
+			numExpired++
+		}
+	}
+	return numExpired
+}
+
+// close forgets all the uploads, once no stream writes to them anymore
+func (u *uploads) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, up := range u.byID {
+		delete(u.byID, id)
+		up.discard()
+	}
+}
+
+// PutStream stores a value uploaded in chunks on the owner of the key, like Put. An upload interrupted
+// by a broken stream resumes from the offset returned by GetUploadOffset, in a new stream with the same
+// upload ID. The value is only stored if its SHA-256 is that of the last chunk, otherwise the upload fails
+// with DataLoss and starts over
+func (s *Server) PutStream(stream pb.Chord_PutStreamServer) (err error) {
+	logger := logrus.WithField("method", "Server.PutStream")
+	ctx := stream.Context()
+	// the client is only told why the upload failed if it isn't sending anymore. The stream is closed
+	// right away if the upload is too large, or if it was forwarded: the owner closed it already
+	in := &putChunks{Chord_PutStreamServer: stream}
+	var forwarded bool
+	defer func() {
+		if !forwarded && status.Code(err) != codes.ResourceExhausted {
+			in.drain(s.uploads.maxSize)
+		}
+	}()
+
+	first, err := in.Recv()
+	if err != nil {
+		return err
+	}
+	logger.Debugf("key=%s upload=%s offset=%d", first.Key, first.UploadId, first.Offset)
+	if err := checkWritable(first.Key); err != nil {
+		return err
+	}
+	if first.UploadId == "" {
+		return errEmptyUploadID
+	}
+	if first.TtlMillis < 0 {
+		return errNegativeTTL
+	}
+
+	if forwarded, err = s.forwardToOwner(ctx, first.Key, first.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Stream(ctx, owner.GetBind(), func(ctx context.Context, client pb.ChordClient) error {
+			return forwardPutStream(ctx, client, first, in)
+		})
+	}); forwarded {
+		return err
+	}
+
+	up, err := s.uploads.acquire(first.Key, first.UploadId, first.Offset)
+	if err != nil {
+		return err
+	}
+	var done bool
+	defer func() {
+		s.uploads.release(first.UploadId, up, done)
+	}()
+
+	chunk := first
+	for {
+		if chunk.Offset != uint64(up.size) {
+			return status.Errorf(codes.FailedPrecondition, "expected the chunk at offset %d, got %d", up.size, chunk.Offset)
+		}
+		if err := up.write(chunk.Data, s.uploads.maxSize); err != nil {
+			// it can't be resumed
+			done = true
+			return err
+		}
+		if chunk.Last {
+			break
+		}
+		if chunk, err = in.Recv(); err == io.EOF {
+			return status.Error(codes.InvalidArgument, "the stream ended before the last chunk")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// the upload is over either way
+	done = true
+	if !bytes.Equal(up.hash.Sum(nil), chunk.Sha256) {
+		return status.Errorf(codes.DataLoss, "the SHA-256 of the value of %q doesn't match", first.Key)
+	}
+	value, err := up.value()
+	if err != nil {
+		return err
+	}
+	item := storage.Item{Key: first.Key, Value: value}
+	if first.TtlMillis > 0 {
+		item.ExpiresAt = time.Now().Add(time.Duration(first.TtlMillis) * time.Millisecond)
+	}
+	item, err = s.replicator.write(ctx, item, first.Consistency, nil)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&pb.PutResponse{Owner: asProtobufRef(s.localNode), Version: item.Version})
+}
+
+// putChunks are the chunks received by PutStream, over once the last one or an error was received
+type putChunks struct {
+	pb.Chord_PutStreamServer
+	over bool
+}
+
+func (c *putChunks) Recv() (*pb.PutChunk, error) {
+	chunk, err := c.Chord_PutStreamServer.Recv()
+	c.over = err != nil || chunk.Last
+	return chunk, err
+}
+
+// drain discards the chunks left, giving up past limit bytes of them
+func (c *putChunks) drain(limit int64) {
+	var discarded int64
+	for !c.over && discarded <= limit {
+		if chunk, err := c.Recv(); err == nil {
+			discarded += int64(len(chunk.Data))
+		}
+	}
+}
+
+// forwardPutStream relays the chunks of an upload to the owner of the key, starting with the first one
+func forwardPutStream(ctx context.Context, client pb.ChordClient, first *pb.PutChunk, in pb.Chord_PutStreamServer) error {
+	out, err := client.PutStream(ctx)
+	if err != nil {
+		return err
+	}
+	chunk := &pb.PutChunk{
+		Key:         first.Key,
+		Forwarded:   true,
+		Consistency: first.Consistency,
+		TtlMillis:   first.TtlMillis,
+		UploadId:    first.UploadId,
+		Offset:      first.Offset,
+		Data:        first.Data,
+		Last:        first.Last,
+		Sha256:      first.Sha256,
+	}
+	for {
+		if err := out.Send(chunk); err != nil {
+			return sendError(err)
+		}
+		if chunk.Last {
+			break
+		}
+		if chunk, err = in.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	resp, err := out.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	return in.SendAndClose(resp)
+}
+
+// GetUploadOffset returns where an upload resumes from, 0 if it's unknown to the owner of the key
+func (s *Server) GetUploadOffset(ctx context.Context, req *pb.UploadOffsetRequest) (*pb.UploadOffsetResponse, error) {
+	if req.Key == "" {
+		return nil, errEmptyKey
+	}
+	if req.UploadId == "" {
+		return nil, errEmptyUploadID
+	}
+
+	var resp *pb.UploadOffsetResponse
+	if forwarded, err := s.forwardToOwner(ctx, req.Key, req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Call(ctx, owner.GetBind(), "GetUploadOffset", func(ctx context.Context, client pb.ChordClient) (err error) {
+			resp, err = client.GetUploadOffset(ctx, &pb.UploadOffsetRequest{Key: req.Key, UploadId: req.UploadId, Forwarded: true})
+			return err
+		})
+	}); forwarded {
+		return resp, err
+	}
+	return &pb.UploadOffsetResponse{Offset: s.uploads.offset(req.UploadId)}, nil
+}
+
+// GetStream reads the value of the key in chunks, for values too large for Get. Like Get, the newest
+// of the copies read as the consistency level requires is sent, and written back to the stale replicas.
+// Fails with NotFound if the key has no value, FailedPrecondition if it's not at the expected version
+func (s *Server) GetStream(req *pb.GetStreamRequest, stream pb.Chord_GetStreamServer) error {
+	logger := logrus.WithField("method", "Server.GetStream")
+	logger.Debugf("key=%s offset=%d", req.Key, req.Offset)
+	ctx := stream.Context()
+
+	if req.Key == "" {
+		return errEmptyKey
+	}
+
+	if forwarded, err := s.forwardToOwner(ctx, req.Key, req.Forwarded, func(owner chord.NodeRef) error {
+		return s.dialer.Stream(ctx, owner.GetBind(), func(ctx context.Context, client pb.ChordClient) error {
+			in, err := client.GetStream(ctx, &pb.GetStreamRequest{
+				Key:         req.Key,
+				Forwarded:   true,
+				Offset:      req.Offset,
+				Version:     req.Version,
+				Consistency: req.Consistency,
+			})
+			if err != nil {
+				return err
+			}
+			for {
+				chunk, err := in.Recv()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := stream.Send(chunk); err != nil {
+					return err
+				}
+			}
+		})
+	}); forwarded {
+		return err
+	}
+
+	read, _, err := s.replicator.read(ctx, req.Key, req.Consistency)
+	if err != nil {
+		return err
+	}
+	item := read.item
+	if !read.found || !item.Live(time.Now()) {
+		return status.Errorf(codes.NotFound, "key %q not found", req.Key)
+	}
+	if req.Version != 0 && req.Version != item.Version {
+		return status.Errorf(codes.FailedPrecondition, "the version of %q is %d, expected %d", req.Key, item.Version, req.Version)
+	}
+	if req.Offset > uint64(len(item.Value)) {
+		return status.Errorf(codes.OutOfRange, "the offset %d is past the size of the value, %d", req.Offset, len(item.Value))
+	}
+
+	sum := sha256.Sum256(item.Value)
+	kv := itemToProto(item)
+	first := &pb.GetChunk{
+		Owner:     asProtobufRef(s.localNode),
+		Version:   item.Version,
+		Size:      uint64(len(item.Value)),
+		Sha256:    sum[:],
+		ExpiresAt: kv.ExpiresAt,
+	}
+	chunk, offset := first, req.Offset
+	for {
+		end := offset + chunkSize
+		if end > uint64(len(item.Value)) {
+			end = uint64(len(item.Value))
+		}
+		chunk.Offset, chunk.Data = offset, item.Value[offset:end]
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+		if offset = end; offset == uint64(len(item.Value)) {
+			return nil
+		}
+		chunk = new(pb.GetChunk)
+	}
+}
+
+// ReplicateStream stores a copy of a key sent in chunks by its owner, or its previous owner, like Replicate.
+// A copy handed off for a replica is kept as a hint, like StoreHints
+func (s *Server) ReplicateStream(stream pb.Chord_ReplicateStreamServer) error {
+	item, hintFor, found, err := receiveItem(stream.Recv)
+	if err != nil {
+		return err
+	}
+	if !found || item.Key == "" {
+		return errEmptyKey
+	}
+	if hintFor != nil {
+		err = s.hints.add((*PBNodeRef)(hintFor), []storage.Item{item})
+	} else {
+		err = s.replicator.merge(item)
+	}
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&pb.ReplicateResponse{})
+}
+
+// GetReplicaStream reads the copy of the key stored on the local node in chunks, like GetReplica.
+// Nothing is sent if there's none
+func (s *Server) GetReplicaStream(req *pb.GetReplicaRequest, stream pb.Chord_GetReplicaStreamServer) error {
+	item, found, err := s.store.Get(req.Key)
+	if err != nil || !found {
+		return err
+	}
+	return sendItem(item, nil, stream.Send)
+}
+
+// sendItem sends the item in chunks, the first one has the item without its value and the replica it's a hint for, if any
+func sendItem(item storage.Item, hintFor chord.NodeRef, send func(*pb.ReplicateChunk) error) error {
+	header := item
+	header.Value = nil
+	chunk, offset := &pb.ReplicateChunk{Item: itemToProto(header)}, 0
+	if hintFor != nil {
+		chunk.HintFor = asProtobufRef(hintFor)
+	}
+	for {
+		end := offset + chunkSize
+		if end > len(item.Value) {
+			end = len(item.Value)
+		}
+		chunk.Data = item.Value[offset:end]
+		if err := send(chunk); err != nil {
+			return err
+		}
+		if offset = end; offset == len(item.Value) {
+			return nil
+		}
+		chunk = new(pb.ReplicateChunk)
+	}
+}
+
+// receiveItem assembles the item sent by sendItem, found is false if nothing was sent
+func receiveItem(recv func() (*pb.ReplicateChunk, error)) (item storage.Item, hintFor *pb.Node, found bool, err error) {
+	var value bytes.Buffer
+	for {
+		chunk, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return item, nil, false, err
+		}
+		if chunk.Item != nil {
+			item, hintFor, found = itemFromProto(chunk.Item), chunk.HintFor, true
+		}
+		value.Write(chunk.Data)
+	}
+	item.Value = value.Bytes()
+	return item, hintFor, found, nil
+}
+
+// pushItem stores the copy of an item too large for Replicate on the node, in chunks.
+// If hintFor isn't nil, the node keeps it as a hint for that replica
+func (r *replicator) pushItem(ctx context.Context, to string, item storage.Item, hintFor chord.NodeRef) error {
+	return r.dialer.Stream(ctx, to, func(ctx context.Context, client pb.ChordClient) error {
+		out, err := client.ReplicateStream(ctx)
+		if err != nil {
+			return err
+		}
+		if err := sendItem(item, hintFor, out.Send); err != nil {
+			return sendError(err)
+		}
+		_, err = out.CloseAndRecv()
+		return err
+	})
+}
+
+// readReplicaStream reads the copy of the key stored on the replica in chunks, for values too large for GetReplica
+func (r *replicator) readReplicaStream(ctx context.Context, replica chord.NodeRef, key string) replicaRead {
+	var read replicaRead
+	read.err = r.dialer.Stream(ctx, replica.GetBind(), func(ctx context.Context, client pb.ChordClient) error {
+		in, err := client.GetReplicaStream(ctx, &pb.GetReplicaRequest{Key: key})
+		if err != nil {
+			return err
+		}
+		read.item, _, read.found, err = receiveItem(in.Recv)
+		return err
+	})
+	if read.err != nil {
+		return replicaRead{err: read.err}
+	}
+	return read
+}
@@ -0,0 +1,314 @@
+package chordio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/kevinjqiu/chordio/pb"
+	"github.com/kevinjqiu/chordio/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+// sendChunks uploads the data from the offset in chunks, the last one with the hash if it's not nil
+func sendChunks(c pb.ChordClient, key, uploadID string, offset int, data []byte, sum []byte) (*pb.PutResponse, error) {
+	stream, err := c.PutStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for {
+		end := offset + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		last := sum != nil && end == len(data)
+		err := stream.Send(&pb.PutChunk{
+			Key:         key,
+			Consistency: pb.Consistency_ALL,
+			UploadId:    uploadID,
+			Offset:      uint64(offset),
+			Data:        data[offset:end],
+			Last:        last,
+			Sha256:      sum,
+		})
+		if err == io.EOF {
+			// the server rejected the upload without reading it all, the test clients aren't traced
+			// so the status can still be received
+			_, err = stream.CloseAndRecv()
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		if offset = end; offset == len(data) {
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// receiveChunks downloads the value of the key from the offset
+func receiveChunks(c pb.ChordClient, key string, offset uint64, consistency pb.Consistency) (*pb.GetChunk, []byte, error) {
+	stream, err := c.GetStream(context.Background(), &pb.GetStreamRequest{Key: key, Offset: offset, Consistency: consistency})
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		first *pb.GetChunk
+		data  []byte
+	)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return first, data, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if first == nil {
+			first = chunk
+		}
+		data = append(data, chunk.Data...)
+	}
+}
+
+func TestStream(t *testing.T) {
+	pair := newReplicatedPair()
+	defer pair.stop()
+	n0, n4 := pair.n0, pair.n4
+
+	// the streams go through the node that doesn't own the key, to be forwarded to the owner
+	_, through := pair.ownerOf("video")
+	c, close := through.getClient()
+	defer close()
+
+	// larger than the default message size limit of grpc
+	value := make([]byte, 5*chunkSize+123)
+	rand.Read(value)
+	sum := sha256.Sum256(value)
+
+	t.Run("a large value is stored on every replica", func(t *testing.T) {
+		resp, err := sendChunks(c, "video", "upload-1", 0, value, sum[:])
+		assert.Nil(t, err)
+		for _, n := range []testNode{n0, n4} {
+			item, found, _ := n.s.store.Get("video")
+			assert.True(t, found)
+			assert.Equal(t, resp.Version, item.Version)
+			assert.True(t, bytes.Equal(value, item.Value))
+		}
+
+		first, data, err := receiveChunks(c, "video", 0, pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(value, data))
+		assert.Equal(t, resp.Version, first.Version)
+		assert.Equal(t, uint64(len(value)), first.Size)
+		assert.Equal(t, sum[:], first.Sha256)
+	})
+
+	t.Run("a download resumes from an offset", func(t *testing.T) {
+		_, data, err := receiveChunks(c, "video", 2*chunkSize+1, pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(value[2*chunkSize+1:], data))
+
+		_, _, err = receiveChunks(c, "video", uint64(len(value)+1), pb.Consistency_ONE)
+		assert.Equal(t, codes.OutOfRange, status.Code(err))
+	})
+
+	t.Run("an interrupted upload resumes from its offset", func(t *testing.T) {
+		// the stream ends before the last chunk
+		_, err := sendChunks(c, "backup", "upload-2", 0, value[:2*chunkSize], nil)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		offset, err := c.GetUploadOffset(context.Background(), &pb.UploadOffsetRequest{Key: "backup", UploadId: "upload-2"})
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(2*chunkSize), offset.Offset)
+
+		_, err = sendChunks(c, "backup", "upload-2", chunkSize, value, sum[:])
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		_, err = sendChunks(c, "backup", "upload-2", 2*chunkSize, value, sum[:])
+		assert.Nil(t, err)
+		_, data, err := receiveChunks(c, "backup", 0, pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(value, data))
+	})
+
+	t.Run("a corrupted upload isn't stored", func(t *testing.T) {
+		corrupted := append([]byte{}, value...)
+		corrupted[chunkSize] ^= 0xff
+		_, err := sendChunks(c, "archive", "upload-3", 0, corrupted, sum[:])
+		assert.Equal(t, codes.DataLoss, status.Code(err))
+
+		_, _, err = receiveChunks(c, "archive", 0, pb.Consistency_ONE)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		// the upload starts over
+		offset, err := c.GetUploadOffset(context.Background(), &pb.UploadOffsetRequest{Key: "archive", UploadId: "upload-3"})
+		assert.Nil(t, err)
+		assert.Zero(t, offset.Offset)
+	})
+}
+
+func TestUploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chordio-uploads")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	u := newUploads(dir, 4, 1)
+	up, err := u.acquire("a", "1", 0)
+	assert.Nil(t, err)
+
+	_, err = u.acquire("a", "1", 0)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	_, err = u.acquire("b", "2", 0)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Nil(t, up.write([]byte("abc"), u.maxSize))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(up.write([]byte("de"), u.maxSize)))
+	u.release("1", up, false)
+
+	_, err = u.acquire("b", "1", 3)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, uint64(3), u.offset("1"))
+	assert.Zero(t, u.expire(up.updated))
+	assert.Equal(t, 1, u.expire(up.updated.Add(1)))
+	assert.Zero(t, u.offset("1"))
+	_, err = os.Stat(up.file.Name())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUploadLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chordio-uploads")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	n := newNodeWithConfig(Config{
+		ID:   0,
+		M:    3,
+		Bind: inprocAddr(0),
+		Stabilization: StabilizationConfig{
+			Disabled: true,
+		},
+		Storage: StorageConfig{
+			MaxValueSize:      2 * chunkSize,
+			MaxPendingUploads: 2,
+			UploadDir:         dir,
+		},
+	})
+	defer n.stop()
+	c, close := n.getClient()
+	defer close()
+
+	value := make([]byte, 2*chunkSize)
+	rand.Read(value)
+	sum := sha256.Sum256(value)
+
+	t.Run("a value larger than the maximum size is rejected", func(t *testing.T) {
+		large := append(append([]byte{}, value...), 0)
+		largeSum := sha256.Sum256(large)
+		_, err := sendChunks(c, "too-large", "upload-1", 0, large, largeSum[:])
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		_, found, _ := n.s.store.Get("too-large")
+		assert.False(t, found)
+		// it can't be resumed
+		offset, err := c.GetUploadOffset(context.Background(), &pb.UploadOffsetRequest{Key: "too-large", UploadId: "upload-1"})
+		assert.Nil(t, err)
+		assert.Zero(t, offset.Offset)
+
+		_, err = sendChunks(c, "large", "upload-2", 0, value, sum[:])
+		assert.Nil(t, err)
+	})
+
+	t.Run("the uploads past the maximum number pending are rejected", func(t *testing.T) {
+		for _, id := range []string{"upload-3", "upload-4"} {
+			_, err := sendChunks(c, "pending", id, 0, value[:chunkSize], nil)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		}
+		_, err := sendChunks(c, "pending", "upload-5", 0, value, sum[:])
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		// the pending uploads can still be resumed
+		_, err = sendChunks(c, "pending", "upload-3", chunkSize, value, sum[:])
+		assert.Nil(t, err)
+		_, err = sendChunks(c, "pending", "upload-5", 0, value, sum[:])
+		assert.Nil(t, err)
+	})
+}
+
+func TestLargeReplicas(t *testing.T) {
+	ctx := context.Background()
+	nodes := map[uint64]testNode{}
+	for _, id := range []int{0, 3, 6} {
+		nodes[uint64(id)] = newReplicatedNode(id, 3, 2)
+		defer nodes[uint64(id)].stop()
+	}
+	nodes[3].join(nodes[0])
+	nodes[6].join(nodes[0])
+	stabilizeRounds(4, nodes[0], nodes[3], nodes[6])
+
+	c, close := nodes[0].getClient()
+	defer close()
+
+	// larger than the default message size limit of grpc
+	value := make([]byte, 5*chunkSize)
+	rand.Read(value)
+	sum := sha256.Sum256(value)
+	resp, err := sendChunks(c, "video", "upload", 0, value, sum[:])
+	assert.Nil(t, err)
+	owner := nodes[resp.Owner.Id]
+	replica := nodes[owner.status().Node.Succ.Id]
+	holder := nodes[replica.status().Node.Succ.Id]
+	replicaRef := &PBNodeRef{Id: replica.id, Bind: replica.addr}
+
+	t.Run("a large copy is read from a replica", func(t *testing.T) {
+		read := owner.s.replicator.readReplica(ctx, replicaRef, "video")
+		assert.Nil(t, read.err)
+		assert.True(t, read.found)
+		assert.True(t, bytes.Equal(value, read.item.Value))
+
+		newest, _, err := owner.s.replicator.read(ctx, "video", pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(value, newest.item.Value))
+	})
+
+	t.Run("anti-entropy pulls a large copy", func(t *testing.T) {
+		assert.Nil(t, owner.s.store.Delete("video"))
+		assert.Nil(t, owner.s.replicator.antiEntropy(ctx))
+		item, found, _ := owner.s.store.Get("video")
+		assert.True(t, found)
+		assert.True(t, bytes.Equal(value, item.Value))
+	})
+
+	t.Run("a large copy is handed off", func(t *testing.T) {
+		item, _, _ := owner.s.store.Get("video")
+		assert.Nil(t, owner.s.replicator.handOff(ctx, replicaRef, []storage.Item{item}))
+		assert.Equal(t, 1, holder.s.hints.size)
+		assert.True(t, bytes.Equal(value, holder.s.hints.byReplica[replica.addr].items["video"].Value))
+	})
+
+	t.Run("a download reads the newest copy as the consistency requires", func(t *testing.T) {
+		item, _, _ := replica.s.store.Get("video")
+		newer := make([]byte, 3*chunkSize)
+		rand.Read(newer)
+		item.Value, item.Version = newer, item.Version+1
+		assert.Nil(t, replica.s.store.Put(item))
+
+		_, data, err := receiveChunks(c, "video", 0, pb.Consistency_ONE)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(value, data))
+
+		first, data, err := receiveChunks(c, "video", 0, pb.Consistency_ALL)
+		assert.Nil(t, err)
+		assert.True(t, bytes.Equal(newer, data))
+		assert.Equal(t, item.Version, first.Version)
+		// and the owner is repaired
+		assert.Eventually(t, func() bool {
+			stored, _, _ := owner.s.store.Get("video")
+			return stored.Version == item.Version
+		}, time.Second, 10*time.Millisecond)
+	})
+}
@@ -1,15 +1,58 @@
 package telemetry
 
+import "time"
+
 type JaegerExporterConfig struct {
 	CollectorEndpoint string `mapstructure:"collectorEndpoint"`
 }
 
+type OTLPExporterConfig struct {
+	// Protocol used to talk to the collector, either "grpc" or "http"
+	Protocol string `mapstructure:"protocol"`
+	// Endpoint is host:port for grpc, or the full URL for http
+	Endpoint string `mapstructure:"endpoint"`
+	// Insecure connects to the grpc collector without TLS, which is verified by CAFile,
+	// or the system roots if it's empty, otherwise
+	Insecure bool              `mapstructure:"insecure"`
+	CAFile   string            `mapstructure:"caFile"`
+	Headers  map[string]string `mapstructure:"headers"`
+}
+
+type StdoutExporterConfig struct {
+	PrettyPrint bool `mapstructure:"prettyPrint"`
+}
+
+type FileExporterConfig struct {
+	// Path of the file the spans and the metrics are appended to, one JSON document per line
+	Path        string `mapstructure:"path"`
+	PrettyPrint bool   `mapstructure:"prettyPrint"`
+}
+
 type ExporterConfig struct {
 	Type   string               `mapstructure:"type"`
 	Jaeger JaegerExporterConfig `mapstructure:"jaeger"`
+	OTLP   OTLPExporterConfig   `mapstructure:"otlp"`
+	Stdout StdoutExporterConfig `mapstructure:"stdout"`
+	File   FileExporterConfig   `mapstructure:"file"`
+}
+
+type SamplerConfig struct {
+	// Type is one of "always", "never" or "ratio", defaults to "always"
+	Type string `mapstructure:"type"`
+	// Ratio of the traces to sample when Type is "ratio"
+	Ratio float64 `mapstructure:"ratio"`
+	// ParentBased follows the sampling decision of the parent span if there is one
+	// and only applies the sampler to root spans
+	ParentBased bool `mapstructure:"parentBased"`
 }
 
 type Config struct {
-	Enabled  bool `mapstructure:"enabled"`
-	Exporter ExporterConfig
+	Enabled     bool   `mapstructure:"enabled"`
+	ServiceName string `mapstructure:"serviceName"`
+	// ResourceAttributes are attached to every span produced by the process
+	ResourceAttributes map[string]string `mapstructure:"resourceAttributes"`
+	Exporter           ExporterConfig    `mapstructure:"exporter"`
+	Sampler            SamplerConfig     `mapstructure:"sampler"`
+	// MetricsPeriod is how often the metrics are pushed to the exporter, DefaultMetricsPeriod if 0
+	MetricsPeriod time.Duration `mapstructure:"metricsPeriod"`
 }
@@ -1,13 +1,34 @@
 package telemetry
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/api/core"
 	"go.opentelemetry.io/otel/api/global"
 	"go.opentelemetry.io/otel/api/key"
+	"go.opentelemetry.io/otel/api/metric"
 	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/exporters/metric/stdout"
+	"go.opentelemetry.io/otel/exporters/otlp"
 	"go.opentelemetry.io/otel/exporters/trace/jaeger"
+	tracestdout "go.opentelemetry.io/otel/exporters/trace/stdout"
+	metricexport "go.opentelemetry.io/otel/sdk/export/metric"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/metric/batcher/ungrouped"
+	"go.opentelemetry.io/otel/sdk/metric/controller/push"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
 )
 
 var (
@@ -22,22 +43,50 @@ func GetServiceName() string {
 	return telemetryServiceName
 }
 
+// Tracer returns the tracer every component of the process should use,
+// so all spans carry the same instrumentation name
+func Tracer() trace.Tracer {
+	return global.Tracer(GetServiceName())
+}
+
+// Meter returns the meter every component of the process should use, named like the tracer
+func Meter() metric.Meter {
+	return global.Meter(GetServiceName())
+}
+
 type FlushFunc func()
 
-const DefaultJaegerCollectorEndpoint = "http://localhost:14268/api/traces"
+const (
+	DefaultJaegerCollectorEndpoint = "http://localhost:14268/api/traces"
+	DefaultOTLPGRPCEndpoint        = "localhost:55680"
+	DefaultMetricsPeriod           = time.Minute
 
-func Init(serviceName string, config Config) (FlushFunc, error) {
+	otlpTraceExportMethod = "/opentelemetry.proto.collector.trace.v1.TraceService/Export"
+)
+
+func Init(config Config) (FlushFunc, error) {
 	var (
-		tp    trace.Provider
-		flush FlushFunc
-		err   error
+		tp      trace.Provider
+		me      metricexport.Exporter
+		flush   FlushFunc
+		sampler sdktrace.Sampler
+		err     error
 	)
 
+	serviceName := config.ServiceName
 	SetServiceName(serviceName)
 
 	if !config.Enabled {
 		tp = trace.NoopProvider{}
+		flush = func() {}
 	} else {
+		sampler, err = newSampler(config.Sampler)
+		if err != nil {
+			return nil, err
+		}
+		sdkConfig := sdktrace.Config{DefaultSampler: sampler}
+		resourceAttrs := resourceAttributes(serviceName, config.ResourceAttributes)
+
 		switch config.Exporter.Type {
 		case "jaeger":
 			jaegerConfig := config.Exporter.Jaeger
@@ -45,22 +94,220 @@ func Init(serviceName string, config Config) (FlushFunc, error) {
 				jaeger.WithCollectorEndpoint(jaegerConfig.CollectorEndpoint),
 				jaeger.WithProcess(jaeger.Process{
 					ServiceName: serviceName,
-					Tags: []core.KeyValue{
+					// the service name is already part of the jaeger process
+					Tags: append([]core.KeyValue{
 						key.String("exporter", "jaeger"),
-					},
+					}, resourceAttrs[1:]...),
 				}),
-				jaeger.WithSDK(&sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+				jaeger.WithSDK(&sdkConfig),
 			)
-
-			if err != nil {
-				return nil, err
-			}
-
+		case "otlp":
+			tp, me, flush, err = newOTLPPipeline(resourceAttrs, sdkConfig, config.Exporter.OTLP)
+		case "stdout":
+			tp, me, flush, err = newJSONPipeline(resourceAttrs, sdkConfig, os.Stdout, config.Exporter.Stdout.PrettyPrint)
+		case "file":
+			tp, me, flush, err = newFilePipeline(resourceAttrs, sdkConfig, config.Exporter.File)
 		default:
 			return nil, fmt.Errorf("unsupported exporter type: %s", config.Exporter.Type)
 		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if me == nil {
+			logrus.Infof("metrics aren't exported by the %s exporter", config.Exporter.Type)
+		} else {
+			flush = installMetricPipeline(resourceAttrs, me, config.MetricsPeriod, flush)
+		}
 	}
 
 	global.SetTraceProvider(tp)
 	return flush, nil
 }
+
+// installMetricPipeline pushes the metrics of the process to exporter every period, DefaultMetricsPeriod if 0.
+// The returned flush pushes them one last time before flushing the spans
+func installMetricPipeline(resourceAttrs []core.KeyValue, exporter metricexport.Exporter, period time.Duration, flush FlushFunc) FlushFunc {
+	if period <= 0 {
+		period = DefaultMetricsPeriod
+	}
+	pusher := push.New(ungrouped.New(simple.NewWithExactMeasure(), true), exporter, period,
+		push.WithResource(resource.New(resourceAttrs...)),
+		push.WithErrorHandler(func(err error) {
+			logrus.Errorf("unable to export metrics: %s", err)
+		}))
+	pusher.Start()
+	global.SetMeterProvider(pusher)
+	return func() {
+		pusher.Stop()
+		flush()
+	}
+}
+
+// resourceAttributes returns the service name and the configured attributes
+// sorted by key, so every exporter reports them in the same order
+func resourceAttributes(serviceName string, attrs map[string]string) []core.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]core.KeyValue, 0, len(attrs)+1)
+	kvs = append(kvs, key.String("service.name", serviceName))
+	for _, k := range keys {
+		kvs = append(kvs, key.String(k, attrs[k]))
+	}
+	return kvs
+}
+
+// newOTLPPipeline exports the spans to the OTLP collector, and the metrics too over grpc
+func newOTLPPipeline(resourceAttrs []core.KeyValue, sdkConfig sdktrace.Config, config OTLPExporterConfig) (trace.Provider, metricexport.Exporter, FlushFunc, error) {
+	switch config.Protocol {
+	case "", "grpc":
+		endpoint := config.Endpoint
+		if endpoint == "" {
+			endpoint = DefaultOTLPGRPCEndpoint
+		}
+		opts := []otlp.ExporterOption{
+			otlp.WithAddress(endpoint),
+			otlp.WithHeaders(config.Headers),
+		}
+		if config.Insecure {
+			opts = append(opts, otlp.WithInsecure())
+		} else {
+			creds, err := otlpCredentials(config.CAFile)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			opts = append(opts, otlp.WithTLSCredentials(creds))
+		}
+		// the exporter keeps retrying silently, so that a collector that can't be reached would go unnoticed
+		opts = append(opts, otlp.WithGRPCDialOption(grpc.WithUnaryInterceptor(logExportErrors)))
+		exporter, err := otlp.NewExporter(opts...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		tp, flush, err := newBatchPipeline(resourceAttrs, sdkConfig, exporter)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return tp, exporter, func() {
+			flush()
+			_ = exporter.Stop()
+		}, nil
+	case "http":
+		tp, flush, err := newBatchPipeline(resourceAttrs, sdkConfig, newOTLPHTTPExporter(config))
+		return tp, nil, flush, err
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported otlp protocol: %s", config.Protocol)
+	}
+}
+
+// otlpCredentials verifies the OTLP collector with the CA in caFile, the system roots if it's empty
+func otlpCredentials(caFile string) (credentials.TransportCredentials, error) {
+	if caFile == "" {
+		return credentials.NewTLS(&tls.Config{}), nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return credentials.NewTLS(&tls.Config{RootCAs: pool}), nil
+}
+
+// logExportErrors logs the span exports that failed, which the OTLP exporter drops without a word.
+// The metric exports return their errors, which are logged by the metric pipeline
+func logExportErrors(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil && method == otlpTraceExportMethod {
+		logrus.Errorf("unable to export spans to the OTLP collector at %s: %s", cc.Target(), err)
+	}
+	return err
+}
+
+func newFilePipeline(resourceAttrs []core.KeyValue, sdkConfig sdktrace.Config, config FileExporterConfig) (trace.Provider, metricexport.Exporter, FlushFunc, error) {
+	if config.Path == "" {
+		return nil, nil, nil, fmt.Errorf("file exporter requires a path")
+	}
+	f, err := os.OpenFile(config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tp, me, flush, err := newJSONPipeline(resourceAttrs, sdkConfig, f, config.PrettyPrint)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return tp, me, func() {
+		flush()
+		f.Close()
+	}, nil
+}
+
+// newJSONPipeline writes the spans and the metrics to w as JSON documents
+func newJSONPipeline(resourceAttrs []core.KeyValue, sdkConfig sdktrace.Config, w io.Writer, prettyPrint bool) (trace.Provider, metricexport.Exporter, FlushFunc, error) {
+	exporter, err := tracestdout.NewExporter(tracestdout.Options{
+		Writer:      w,
+		PrettyPrint: prettyPrint,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	metricExporter, err := stdout.NewRawExporter(stdout.Config{
+		Writer:      w,
+		PrettyPrint: prettyPrint,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	tp, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdkConfig),
+		sdktrace.WithResourceAttributes(resourceAttrs...),
+		sdktrace.WithSyncer(exporter),
+	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return tp, skipEmptyExports{metricExporter}, func() {}, nil
+}
+
+// skipEmptyExports drops the exports without any metric, which the stdout exporter would still write
+type skipEmptyExports struct {
+	metricexport.Exporter
+}
+
+func (e skipEmptyExports) Export(ctx context.Context, resource *resource.Resource, checkpointSet metricexport.CheckpointSet) error {
+	empty := true
+	_ = checkpointSet.ForEach(func(metricexport.Record) error {
+		empty = false
+		return nil
+	})
+	if empty {
+		return nil
+	}
+	return e.Exporter.Export(ctx, resource, checkpointSet)
+}
+
+func newBatchPipeline(resourceAttrs []core.KeyValue, sdkConfig sdktrace.Config, exporter export.SpanBatcher) (trace.Provider, FlushFunc, error) {
+	bsp, err := sdktrace.NewBatchSpanProcessor(exporter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdkConfig),
+		sdktrace.WithResourceAttributes(resourceAttrs...),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	tp.RegisterSpanProcessor(bsp)
+	return tp, bsp.Shutdown, nil
+}
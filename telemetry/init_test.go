@@ -0,0 +1,105 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/metric"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	t.Run("file exporter writes one JSON span per line", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "chordio-telemetry")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "traces.json")
+
+		flush, err := Init(Config{
+			Enabled:     true,
+			ServiceName: "chordio/test",
+			Exporter: ExporterConfig{
+				Type: "file",
+				File: FileExporterConfig{Path: path},
+			},
+		})
+		assert.Nil(t, err)
+
+		_, span := global.Tracer(GetServiceName()).Start(context.Background(), "test-span")
+		span.End()
+		flush()
+
+		b, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+		assert.Equal(t, 1, len(lines))
+
+		var span_ map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(lines[0]), &span_))
+		assert.Equal(t, "test-span", span_["Name"])
+	})
+
+	t.Run("file exporter writes the metrics when flushed", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "chordio-telemetry")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "telemetry.json")
+
+		flush, err := Init(Config{
+			Enabled:     true,
+			ServiceName: "chordio/test",
+			Exporter: ExporterConfig{
+				Type: "file",
+				File: FileExporterConfig{Path: path},
+			},
+		})
+		assert.Nil(t, err)
+
+		counter := metric.Must(Meter()).NewInt64Counter("test.counter")
+		counter.Add(context.Background(), 2)
+		counter.Add(context.Background(), 3)
+		flush()
+
+		b, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		var metrics struct {
+			Updates []struct {
+				Name string      `json:"name"`
+				Sum  json.Number `json:"sum"`
+			} `json:"updates"`
+		}
+		assert.Nil(t, json.Unmarshal(b, &metrics))
+		assert.Equal(t, 1, len(metrics.Updates))
+		assert.True(t, strings.HasPrefix(metrics.Updates[0].Name, "test.counter"))
+		assert.Equal(t, "5", metrics.Updates[0].Sum.String())
+	})
+
+	t.Run("the OTLP collector is verified by the CA file", func(t *testing.T) {
+		_, err := otlpCredentials("")
+		assert.Nil(t, err)
+
+		dir, err := ioutil.TempDir("", "chordio-telemetry")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "ca.pem")
+		assert.Nil(t, ioutil.WriteFile(path, []byte("not a certificate"), 0644))
+
+		_, err = otlpCredentials(path)
+		assert.NotNil(t, err)
+		_, err = otlpCredentials(filepath.Join(dir, "missing.pem"))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unsupported exporter", func(t *testing.T) {
+		_, err := Init(Config{
+			Enabled:  true,
+			Exporter: ExporterConfig{Type: "zipkin"},
+		})
+		assert.NotNil(t, err)
+	})
+}
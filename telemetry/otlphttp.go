@@ -0,0 +1,195 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	coltracepb "github.com/open-telemetry/opentelemetry-proto/gen/go/collector/trace/v1"
+	commonpb "github.com/open-telemetry/opentelemetry-proto/gen/go/common/v1"
+	resourcepb "github.com/open-telemetry/opentelemetry-proto/gen/go/resource/v1"
+	tracepb "github.com/open-telemetry/opentelemetry-proto/gen/go/trace/v1"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/core"
+	apitrace "go.opentelemetry.io/otel/api/trace"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const DefaultOTLPHTTPEndpoint = "http://localhost:55681/v1/trace"
+
+// otlpHTTPExporter posts protobuf encoded spans to an OTLP/HTTP collector endpoint
+type otlpHTTPExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+func (e *otlpHTTPExporter) ExportSpans(ctx context.Context, sds []*export.SpanData) {
+	if len(sds) == 0 {
+		return
+	}
+
+	body, err := proto.Marshal(&coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: otlpResourceSpans(sds),
+	})
+	if err != nil {
+		logrus.Error("unable to encode spans: ", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		logrus.Error("unable to create the OTLP request: ", err)
+		return
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		logrus.Error("unable to export spans: ", err)
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		logrus.Error(fmt.Sprintf("OTLP collector rejected %d spans: %s", len(sds), resp.Status))
+	}
+}
+
+func newOTLPHTTPExporter(config OTLPExporterConfig) *otlpHTTPExporter {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultOTLPHTTPEndpoint
+	}
+	return &otlpHTTPExporter{
+		endpoint: endpoint,
+		headers:  config.Headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func otlpResourceSpans(sds []*export.SpanData) []*tracepb.ResourceSpans {
+	// spans are grouped by the resource that produced them
+	byResource := make(map[interface{}]*tracepb.ResourceSpans)
+	rss := make([]*tracepb.ResourceSpans, 0, 1)
+
+	for _, sd := range sds {
+		var (
+			key      interface{}
+			resource *resourcepb.Resource
+		)
+		if sd.Resource != nil {
+			key = sd.Resource.Equivalent()
+			resource = &resourcepb.Resource{Attributes: otlpAttributes(sd.Resource.Attributes())}
+		}
+
+		rs, ok := byResource[key]
+		if !ok {
+			rs = &tracepb.ResourceSpans{
+				Resource:                    resource,
+				InstrumentationLibrarySpans: []*tracepb.InstrumentationLibrarySpans{{}},
+			}
+			byResource[key] = rs
+			rss = append(rss, rs)
+		}
+		rs.InstrumentationLibrarySpans[0].Spans = append(rs.InstrumentationLibrarySpans[0].Spans, otlpSpan(sd))
+	}
+	return rss
+}
+
+func otlpSpan(sd *export.SpanData) *tracepb.Span {
+	s := &tracepb.Span{
+		TraceId:           sd.SpanContext.TraceID[:],
+		SpanId:            sd.SpanContext.SpanID[:],
+		Name:              sd.Name,
+		Kind:              otlpSpanKind(sd.SpanKind),
+		StartTimeUnixNano: uint64(sd.StartTime.UnixNano()),
+		EndTimeUnixNano:   uint64(sd.EndTime.UnixNano()),
+		Attributes:        otlpAttributes(sd.Attributes),
+		Status: &tracepb.Status{
+			Code:    tracepb.Status_StatusCode(sd.StatusCode),
+			Message: sd.StatusMessage,
+		},
+		DroppedAttributesCount: uint32(sd.DroppedAttributeCount),
+		DroppedEventsCount:     uint32(sd.DroppedMessageEventCount),
+		DroppedLinksCount:      uint32(sd.DroppedLinkCount),
+	}
+
+	if sd.ParentSpanID.IsValid() {
+		s.ParentSpanId = sd.ParentSpanID[:]
+	}
+
+	for _, e := range sd.MessageEvents {
+		s.Events = append(s.Events, &tracepb.Span_Event{
+			Name:         e.Name,
+			TimeUnixNano: uint64(e.Time.UnixNano()),
+			Attributes:   otlpAttributes(e.Attributes),
+		})
+	}
+
+	for _, l := range sd.Links {
+		l := l
+		s.Links = append(s.Links, &tracepb.Span_Link{
+			TraceId:    l.TraceID[:],
+			SpanId:     l.SpanID[:],
+			Attributes: otlpAttributes(l.Attributes),
+		})
+	}
+	return s
+}
+
+func otlpSpanKind(kind apitrace.SpanKind) tracepb.Span_SpanKind {
+	switch kind {
+	case apitrace.SpanKindInternal:
+		return tracepb.Span_INTERNAL
+	case apitrace.SpanKindClient:
+		return tracepb.Span_CLIENT
+	case apitrace.SpanKindServer:
+		return tracepb.Span_SERVER
+	case apitrace.SpanKindProducer:
+		return tracepb.Span_PRODUCER
+	case apitrace.SpanKindConsumer:
+		return tracepb.Span_CONSUMER
+	default:
+		return tracepb.Span_SPAN_KIND_UNSPECIFIED
+	}
+}
+
+func otlpAttributes(kvs []core.KeyValue) []*commonpb.AttributeKeyValue {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	attrs := make([]*commonpb.AttributeKeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		attr := &commonpb.AttributeKeyValue{Key: string(kv.Key)}
+		switch kv.Value.Type() {
+		case core.BOOL:
+			attr.Type = commonpb.AttributeKeyValue_BOOL
+			attr.BoolValue = kv.Value.AsBool()
+		case core.INT32, core.INT64, core.UINT32, core.UINT64:
+			attr.Type = commonpb.AttributeKeyValue_INT
+			attr.IntValue = kv.Value.AsInt64()
+		case core.FLOAT32:
+			attr.Type = commonpb.AttributeKeyValue_DOUBLE
+			attr.DoubleValue = float64(kv.Value.AsFloat32())
+		case core.FLOAT64:
+			attr.Type = commonpb.AttributeKeyValue_DOUBLE
+			attr.DoubleValue = kv.Value.AsFloat64()
+		default:
+			attr.Type = commonpb.AttributeKeyValue_STRING
+			attr.StringValue = kv.Value.Emit()
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
@@ -0,0 +1,49 @@
+package telemetry
+
+import (
+	"fmt"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// parentBasedSampler respects the sampling decision of the parent span
+// and delegates to the root sampler for spans without a parent
+type parentBasedSampler struct {
+	root sdktrace.Sampler
+}
+
+func (pbs parentBasedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if !p.ParentContext.IsValid() {
+		return pbs.root.ShouldSample(p)
+	}
+	if p.ParentContext.IsSampled() {
+		return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSampled}
+	}
+	return sdktrace.SamplingResult{Decision: sdktrace.NotRecord}
+}
+
+func (pbs parentBasedSampler) Description() string {
+	return fmt.Sprintf("ParentBased{root:%s}", pbs.root.Description())
+}
+
+func newSampler(config SamplerConfig) (sdktrace.Sampler, error) {
+	var sampler sdktrace.Sampler
+
+	switch config.Type {
+	case "", "always":
+		sampler = sdktrace.AlwaysSample()
+	case "never":
+		sampler = sdktrace.NeverSample()
+	case "ratio":
+		if config.Ratio < 0 || config.Ratio > 1 {
+			return nil, fmt.Errorf("sampler ratio must be between 0 and 1, got: %g", config.Ratio)
+		}
+		sampler = sdktrace.ProbabilitySampler(config.Ratio)
+	default:
+		return nil, fmt.Errorf("unsupported sampler type: %s", config.Type)
+	}
+
+	if config.ParentBased {
+		sampler = parentBasedSampler{root: sampler}
+	}
+	return sampler, nil
+}
@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/api/core"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"testing"
+)
+
+func TestNewSampler(t *testing.T) {
+	traceID, _ := core.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := core.SpanIDFromHex("0102030405060708")
+	root := sdktrace.SamplingParameters{TraceID: traceID}
+	sampledParent := sdktrace.SamplingParameters{
+		TraceID: traceID,
+		ParentContext: core.SpanContext{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: core.TraceFlagsSampled,
+		},
+	}
+	unsampledParent := sdktrace.SamplingParameters{
+		TraceID: traceID,
+		ParentContext: core.SpanContext{
+			TraceID: traceID,
+			SpanID:  spanID,
+		},
+	}
+
+	t.Run("defaults to always sample", func(t *testing.T) {
+		s, err := newSampler(SamplerConfig{})
+		assert.Nil(t, err)
+		assert.Equal(t, sdktrace.RecordAndSampled, s.ShouldSample(root).Decision)
+	})
+
+	t.Run("never", func(t *testing.T) {
+		s, err := newSampler(SamplerConfig{Type: "never"})
+		assert.Nil(t, err)
+		assert.Equal(t, sdktrace.NotRecord, s.ShouldSample(root).Decision)
+	})
+
+	t.Run("ratio must be between 0 and 1", func(t *testing.T) {
+		_, err := newSampler(SamplerConfig{Type: "ratio", Ratio: 1.5})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("parent based follows the parent decision", func(t *testing.T) {
+		s, err := newSampler(SamplerConfig{Type: "ratio", Ratio: 0, ParentBased: true})
+		assert.Nil(t, err)
+		assert.Equal(t, sdktrace.NotRecord, s.ShouldSample(root).Decision)
+		assert.Equal(t, sdktrace.RecordAndSampled, s.ShouldSample(sampledParent).Decision)
+
+		s, err = newSampler(SamplerConfig{Type: "always", ParentBased: true})
+		assert.Nil(t, err)
+		assert.Equal(t, sdktrace.RecordAndSampled, s.ShouldSample(root).Decision)
+		assert.Equal(t, sdktrace.NotRecord, s.ShouldSample(unsampledParent).Decision)
+	})
+
+	t.Run("unsupported sampler", func(t *testing.T) {
+		_, err := newSampler(SamplerConfig{Type: "sometimes"})
+		assert.NotNil(t, err)
+	})
+}
@@ -3,15 +3,26 @@ package chordio
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"github.com/kevinjqiu/chordio/chord"
 	"github.com/kevinjqiu/chordio/pb"
-	"github.com/phayes/freeport"
+	"github.com/kevinjqiu/chordio/transport"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io/ioutil"
+	"math/big"
 	"math/rand"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -61,10 +72,17 @@ type testNode struct {
 	id   uint64
 	s    *Server
 	addr string
+	// stop the server and wait for it to return
+	stop func()
 }
 
-func (tn testNode) stop() {
-	tn.s.GracefulStop()
+// crash stops the server without handing its keys over nor relinking its neighbours, like a failure:
+// a node that doesn't know its predecessor has no range to hand over
+func (tn testNode) crash() {
+	if err := tn.s.localNode.SetPredNode(context.Background(), nil); err != nil {
+		panic(err)
+	}
+	tn.stop()
 }
 
 func (tn testNode) status() *pb.GetNodeInfoResponse {
@@ -79,6 +97,25 @@ func (tn testNode) status() *pb.GetNodeInfoResponse {
 	return resp
 }
 
+func (tn testNode) dial() (*grpc.ClientConn, error) {
+	return grpc.Dial(tn.addr, grpc.WithInsecure(), grpc.WithContextDialer(transport.Dial), grpc.WithDefaultServiceConfig(defaultServiceConfig))
+}
+
+func (tn testNode) health() healthpb.HealthCheckResponse_ServingStatus {
+	conn, err := tn.dial()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	// a node that's just been started may not be serving yet
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		panic(err)
+	}
+	return resp.Status
+}
+
 func (tn testNode) assertFingerTable(t *testing.T, expectedFTEs []string) {
 	resp := tn.status()
 	actualFTEs := strings.Split(strings.TrimSpace(ftCSV(resp.Ft)), "\n")
@@ -115,25 +152,117 @@ func (tn testNode) stabilize() (int, error) {
 	return int(resp.NumFingerTableEntryChanges), err
 }
 
-func (tn testNode) getClient() (pb.ChordClient, func() error) {
-	conn, err := grpc.Dial(tn.addr, grpc.WithInsecure(), grpc.WithDefaultServiceConfig(defaultServiceConfig))
+// watchTimeout bounds a watch, so that a test waiting for an event that never comes fails instead of hanging
+const watchTimeout = 10 * time.Second
+
+// watch the events of the node until the returned function is called or the watch timed out,
+// the channel is closed once the stream ended
+func (tn testNode) watch() (<-chan *pb.Event, func()) {
+	c, closeConn := tn.getClient()
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
 
+	stream, err := c.WatchEvents(ctx, &pb.WatchEventsRequest{})
 	if err != nil {
 		panic(err)
 	}
-	client := pb.NewChordClient(conn)
+	// the server sends the headers once it has subscribed
+	if _, err := stream.Header(); err != nil {
+		panic(err)
+	}
 
-	return client, func() error { return conn.Close() }
+	events := make(chan *pb.Event, 100)
+	go func() {
+		defer closeConn()
+		defer close(events)
+		for {
+			e, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, cancel
 }
 
-func newNode(id int, m int) testNode {
-	port, err := freeport.GetFreePort()
+func (tn testNode) put(key, value string, consistency pb.Consistency) error {
+	c, close := tn.getClient()
+	defer close()
+
+	_, err := c.Put(context.Background(), &pb.PutRequest{Key: key, Value: []byte(value), Consistency: consistency})
+	return err
+}
+
+func (tn testNode) get(key string, consistency pb.Consistency) (*pb.GetResponse, error) {
+	c, close := tn.getClient()
+	defer close()
+
+	return c.Get(context.Background(), &pb.GetRequest{Key: key, Consistency: consistency})
+}
+
+// stores reports whether the key is in the local storage of the node
+func (tn testNode) stores(key string) bool {
+	_, ok, err := tn.s.store.Get(key)
+	if err != nil {
+		panic(err)
+	}
+	return ok
+}
+
+func (tn testNode) getClient() (pb.ChordClient, func() error) {
+	conn, err := tn.dial()
 	if err != nil {
 		panic(err)
 	}
+	client := pb.NewChordClient(conn)
+
+	return client, func() error { return conn.Close() }
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to dir
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chordio"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(cryptorand.Reader, &template, &template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return certFile, keyFile
+}
+
+// numTestNodes makes the in-process address of every test node unique
+var numTestNodes int64
+
+// inprocAddr returns a unique in-process address for a test node
+func inprocAddr(id int) string {
+	return fmt.Sprintf("inproc://node-%d-%d", id, atomic.AddInt64(&numTestNodes, 1))
+}
+
+// newNode starts a node listening on the in-process transport
+func newNode(id int, m int) testNode {
+	return newNodeAt(id, m, inprocAddr(id))
+}
 
-	addr := fmt.Sprintf("127.0.0.1:%d", port)
-	server, err := NewServer(Config{
+func newNodeAt(id int, m int, addr string) testNode {
+	return newNodeWithConfig(Config{
 		ID:   chord.ID(id),
 		M:    chord.Rank(m),
 		Bind: addr,
@@ -141,21 +270,76 @@ func newNode(id int, m int) testNode {
 			Disabled: true,
 		},
 	})
+}
+
+// newReplicatedNode starts a node on the in-process transport storing its keys on factor nodes
+func newReplicatedNode(id int, m int, factor int) testNode {
+	return newNodeWithConfig(Config{
+		ID:   chord.ID(id),
+		M:    chord.Rank(m),
+		Bind: inprocAddr(id),
+		Stabilization: StabilizationConfig{
+			Disabled: true,
+		},
+		Replication: ReplicationConfig{
+			Factor: factor,
+		},
+	})
+}
+
+// replicatedPair is a ring of the nodes 0 and 4 of rank 3, each storing a copy of the keys of the other
+type replicatedPair struct {
+	n0, n4 testNode
+}
+
+// newReplicatedPair starts the nodes of the pair, joins and stabilizes them
+func newReplicatedPair() replicatedPair {
+	p := replicatedPair{
+		n0: newReplicatedNode(0, 3, 2),
+		n4: newReplicatedNode(4, 3, 2),
+	}
+	p.n4.join(p.n0)
+	stabilizeRounds(3, p.n0, p.n4)
+	return p
+}
+
+func (p replicatedPair) stop() {
+	p.n0.stop()
+	p.n4.stop()
+}
+
+// ownerOf returns the owner of the key and its replica
+func (p replicatedPair) ownerOf(key string) (testNode, testNode) {
+	if chord.NewInterval(3, 0, 4, chord.WithLeftOpen, chord.WithRightClosed).Has(keyID(key, 3)) {
+		return p.n4, p.n0
+	}
+	return p.n0, p.n4
+}
+
+func newNodeWithConfig(config Config) testNode {
+	server, err := NewServer(config)
 	if err != nil {
 		panic(err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
 	go func() {
-		if err := server.Serve(); err != nil {
+		defer close(done)
+		if err := server.Run(ctx); err != nil {
 			fmt.Println(err)
 		}
 	}()
 
 	return testNode{
-		m:    uint32(m),
-		id:   uint64(id),
+		m:    uint32(config.M),
+		id:   uint64(config.ID),
 		s:    server,
-		addr: addr,
+		addr: config.Bind,
+		stop: func() {
+			cancel()
+			<-done
+		},
 	}
 }
 
@@ -183,31 +367,31 @@ func waitForStabilization(threshold int, nodes map[int]testNode) {
 		shouldStop := make(chan bool)
 		stopChans[id] = shouldStop
 
-		go func(id int, shouldStop <- chan bool) {
+		go func(id int, n testNode, shouldStop <-chan bool) {
 			rand.Seed(time.Now().UnixNano())
 			for {
-				duration := time.Duration(rand.Int63() % 5e9) + time.Second
+				duration := time.Duration(rand.Int63()%5e9) + time.Second
 				fmt.Println("sleep duration: ", duration)
 				timer := time.NewTimer(duration)
 
 				select {
-				case <- timer.C:
+				case <-timer.C:
 					n, err := n.stabilize()
 					if err == nil {
 						numChangesChan <- [2]int{id, n}
 					}
-				case <- shouldStop:
+				case <-shouldStop:
 					return
 				}
 			}
-		}(id, shouldStop)
+		}(id, n, shouldStop)
 	}
 
 	nodeChangeHistory := map[int][]int{}
 
 	for {
 		select {
-		case res := <- numChangesChan:
+		case res := <-numChangesChan:
 			id := res[0]
 			n := res[1]
 			hist, ok := nodeChangeHistory[id]
@@ -237,4 +421,4 @@ func waitForStabilization(threshold int, nodes map[int]testNode) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,62 @@
+package chordio
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"io/ioutil"
+)
+
+func (c TLSConfig) loadCAPool() (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the CA file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificate found in the CA file %s", c.CAFile)
+	}
+	return pool, nil
+}
+
+// ServerCredentials for the grpc server
+func (c TLSConfig) ServerCredentials() (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load the certificate")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if c.CAFile != "" {
+		pool, err := c.loadCAPool()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// ClientCredentials for connecting to a server, either from a peer or from the client
+func (c TLSConfig) ClientCredentials() (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load the certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pool, err := c.loadCAPool()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
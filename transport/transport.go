@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// UnixScheme prefixes the path of a unix domain socket, e.g. unix:///var/run/chordio.sock
+	UnixScheme = "unix://"
+	// InProcScheme prefixes the name of an in-memory listener of the current process, e.g. inproc://node-1
+	InProcScheme = "inproc://"
+
+	inProcBufferSize = 1024 * 1024
+)
+
+var (
+	inProcMu        sync.Mutex
+	inProcListeners = map[string]*inProcListener{}
+)
+
+// IsLocal tells whether the address is a unix domain socket or an in-process one,
+// as opposed to a TCP host:port
+func IsLocal(addr string) bool {
+	return strings.HasPrefix(addr, UnixScheme) || strings.HasPrefix(addr, InProcScheme)
+}
+
+// Listen announces on the address, which is a TCP host:port, a unix:// path or an inproc:// name
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, UnixScheme):
+		path := strings.TrimPrefix(addr, UnixScheme)
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	case strings.HasPrefix(addr, InProcScheme):
+		return listenInProc(strings.TrimPrefix(addr, InProcScheme))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+// removeStaleSocket removes the socket file at path left over by a process that didn't exit cleanly,
+// which would fail the listen. A socket still accepting connections belongs to a live process and is kept
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket %s is in use by another process", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// Dial connects to the address, it's meant to be used with grpc.WithContextDialer
+// so the remote nodes can be reached through any transport
+func Dial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	switch {
+	case strings.HasPrefix(addr, UnixScheme):
+		return d.DialContext(ctx, "unix", strings.TrimPrefix(addr, UnixScheme))
+	case strings.HasPrefix(addr, InProcScheme):
+		return dialInProc(strings.TrimPrefix(addr, InProcScheme))
+	default:
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// inProcListener unregisters itself on close so the name can be reused
+type inProcListener struct {
+	*bufconn.Listener
+	name string
+	once sync.Once
+}
+
+func (l *inProcListener) Close() error {
+	l.once.Do(func() {
+		inProcMu.Lock()
+		defer inProcMu.Unlock()
+		delete(inProcListeners, l.name)
+	})
+	return l.Listener.Close()
+}
+
+func listenInProc(name string) (net.Listener, error) {
+	if name == "" {
+		return nil, errors.New("in-process address must have a name")
+	}
+
+	inProcMu.Lock()
+	defer inProcMu.Unlock()
+	if _, ok := inProcListeners[name]; ok {
+		return nil, fmt.Errorf("in-process address %s is already in use", name)
+	}
+	l := &inProcListener{Listener: bufconn.Listen(inProcBufferSize), name: name}
+	inProcListeners[name] = l
+	return l, nil
+}
+
+func dialInProc(name string) (net.Conn, error) {
+	inProcMu.Lock()
+	l, ok := inProcListeners[name]
+	inProcMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no in-process listener at %s%s", InProcScheme, name)
+	}
+	return l.Dial()
+}
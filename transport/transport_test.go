@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func assertRoundTrip(t *testing.T, addr string) {
+	lis, err := Listen(addr)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer lis.Close()
+
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		if _, err := conn.Read(buf); err == nil {
+			conn.Write(buf)
+		}
+	}()
+
+	if !IsLocal(addr) {
+		// the port was picked by the listener
+		addr = lis.Addr().String()
+	}
+	conn, err := Dial(context.Background(), addr)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chordio-transport")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("tcp", func(t *testing.T) {
+		assertRoundTrip(t, "127.0.0.1:0")
+	})
+
+	t.Run("unix domain socket", func(t *testing.T) {
+		assertRoundTrip(t, "unix://"+filepath.Join(dir, "node.sock"))
+	})
+
+	t.Run("a stale unix domain socket is replaced", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+		lis, err := net.Listen("unix", path)
+		assert.Nil(t, err)
+		// keep the socket file around as if the process had crashed
+		lis.(*net.UnixListener).SetUnlinkOnClose(false)
+		lis.Close()
+
+		assertRoundTrip(t, "unix://"+path)
+	})
+
+	t.Run("the unix domain socket of a live process is kept", func(t *testing.T) {
+		path := filepath.Join(dir, "live.sock")
+		lis, err := Listen("unix://" + path)
+		assert.Nil(t, err)
+		defer lis.Close()
+
+		_, err = Listen("unix://" + path)
+		assert.NotNil(t, err)
+		conn, err := Dial(context.Background(), "unix://"+path)
+		assert.Nil(t, err)
+		conn.Close()
+	})
+
+	t.Run("in-process", func(t *testing.T) {
+		assertRoundTrip(t, "inproc://node")
+		// the name is released on close
+		assertRoundTrip(t, "inproc://node")
+	})
+
+	t.Run("in-process names are exclusive", func(t *testing.T) {
+		lis, err := Listen("inproc://taken")
+		assert.Nil(t, err)
+		defer lis.Close()
+
+		_, err = Listen("inproc://taken")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("dialing an unknown in-process name fails", func(t *testing.T) {
+		_, err := Dial(context.Background(), "inproc://unknown")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("local addresses", func(t *testing.T) {
+		assert.True(t, IsLocal("unix:///var/run/chordio.sock"))
+		assert.True(t, IsLocal("inproc://node"))
+		assert.False(t, IsLocal("127.0.0.1:2000"))
+		assert.False(t, IsLocal("[::1]:2000"))
+	})
+}